@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/bloomberg"
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/memcache"
+	"github.com/aktau/gofinance/morningstar"
+	"github.com/aktau/gofinance/sqlitecache"
+	"github.com/aktau/gofinance/yahoofinance"
+)
+
+/* the options every command understands, they can be given before or
+ * after the name of the command */
+type options struct {
+	config    string
+	source    string
+	db        string
+	nocache   bool
+	verbosity int
+	timeout   time.Duration
+	file      string
+	watchlist string
+
+	quoteExpiry   time.Duration
+	staleOnError  bool
+	keepSnapshots bool
+
+	format string
+	color  bool
+}
+
+/* the configuration provides the defaults */
+func defaultOptions(cfg *config) *options {
+	o := &options{
+		config:        ConfigPath(),
+		source:        strings.Join(cfg.Sources, ","),
+		db:            DbPath(),
+		nocache:       cfg.Cache.Disabled,
+		timeout:       FETCH_TIMEOUT,
+		quoteExpiry:   time.Duration(cfg.Cache.QuoteExpiry),
+		staleOnError:  cfg.Cache.StaleOnError,
+		keepSnapshots: cfg.Cache.KeepSnapshots,
+		format:        FORMAT_TEXT,
+		color:         colorByDefault(),
+	}
+
+	/* the environment goes before the configuration file */
+	if os.Getenv("GOFINANCE_DB") == "" && cfg.Cache.Path != "" {
+		o.db = cfg.Cache.Path
+		if !filepath.IsAbs(o.db) {
+			o.db = filepath.Join(ConfigDir(), o.db)
+		}
+	}
+	return o
+}
+
+/* the current values are the defaults, so that the options of a command
+ * don't undo the ones given before it */
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", o.config, "the configuration file (or set GOFINANCE_DIR)")
+	fs.StringVar(&o.source, "source", o.source,
+		"where to get the data: "+strings.Join(sourceNames(), ", ")+", combine several with commas")
+	fs.StringVar(&o.db, "db", o.db, "the cache database (or set GOFINANCE_DB)")
+	fs.BoolVar(&o.nocache, "nocache", o.nocache, "don't use the cache")
+	fs.IntVar(&o.verbosity, "v", o.verbosity, "verbosity (0-2)")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "give up on the source after this long")
+	fs.StringVar(&o.file, "f", o.file, "read symbols from this file (- for stdin), next to the ones given")
+	fs.StringVar(&o.watchlist, "w", o.watchlist, "add the symbols of this watchlist of the configuration")
+	fs.DurationVar(&o.quoteExpiry, "quote-expiry", o.quoteExpiry, "fetch cached quotes again after this long")
+	fs.BoolVar(&o.staleOnError, "stale", o.staleOnError, "show cached data when it can't be updated")
+	fs.BoolVar(&o.keepSnapshots, "snapshots", o.keepSnapshots, "keep every quote that's fetched in the cache")
+	fs.StringVar(&o.format, "format", o.format, "how to print the results: "+strings.Join(formats, ", "))
+	fs.BoolVar(&o.color, "color", o.color, "colour the text (default when printing to a terminal, unless NO_COLOR is set)")
+}
+
+/* the configuration has to be read before the flags are set up, as it
+ * provides their defaults. Returns the path given with -config, if any. */
+func configFlag(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config="), true
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+var sources = map[string]func() fquery.Source{
+	"bloomberg":   func() fquery.Source { return bloomberg.New() },
+	"yahoo":       func() fquery.Source { return yahoofinance.New() },
+	"morningstar": func() fquery.Source { return morningstar.New() },
+}
+
+/* the configuration was validated when it was read, the flags weren't */
+func (o *options) validate() error {
+	switch {
+	case o.quoteExpiry < 0:
+		return errors.New("the quote expiry can't be negative")
+	case o.timeout <= 0:
+		return errors.New("the timeout has to be positive")
+	case !validFormat(o.format):
+		return fmt.Errorf("unknown format '%v', choose from %v", o.format, strings.Join(formats, ", "))
+	}
+	return nil
+}
+
+func sourceNames() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* builds the source the options ask for, several sources are combined
+ * into one */
+func (o *options) newSource(cfg *config) (fquery.Source, error) {
+	var names []string
+	for _, name := range strings.Split(o.source, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	return cfg.newSource(names)
+}
+
+func (o *options) setVerbosity() {
+	VERBOSITY = o.verbosity
+	bloomberg.VERBOSITY = o.verbosity
+	yahoofinance.VERBOSITY = o.verbosity
+	morningstar.VERBOSITY = o.verbosity
+	sqlitecache.VERBOSITY = o.verbosity
+	memcache.VERBOSITY = o.verbosity
+}
+
+/* the symbols given as arguments, in the watchlist and in the file, if
+ * any */
+func (o *options) symbols(args []string, cfg *config) ([]string, error) {
+	symbols := append([]string(nil), args...)
+	if o.watchlist != "" {
+		more, err := cfg.watchlist(o.watchlist)
+		if err != nil {
+			return nil, err
+		}
+		symbols = append(symbols, more...)
+	}
+	if o.file == "" {
+		return symbols, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if o.file != "-" {
+		f, err := os.Open(o.file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	more, err := readSymbols(r)
+	return append(symbols, more...), err
+}
+
+/* symbols are separated by whitespace, everything after a # is a
+ * comment */
+func readSymbols(r io.Reader) ([]string, error) {
+	var symbols []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		symbols = append(symbols, strings.Fields(line)...)
+	}
+	return symbols, scanner.Err()
+}
+
+/* what a command gets to work with */
+type env struct {
+	ctx     context.Context
+	src     fquery.Source
+	symbols []string
+	out     *output
+}
+
+type command struct {
+	name    string
+	args    string /* shown in the usage */
+	summary string
+
+	/* whether the command needs at least one symbol */
+	needsSymbols bool
+
+	/* registers the flags of the command, with the configuration as the
+	 * defaults, and returns what runs it. False means failure. */
+	setup func(fs *flag.FlagSet, cfg *config) func(e *env) bool
+}
+
+var commands = []*command{
+	{
+		name:         "quote",
+		args:         "[thresholds] [costs] symbol...",
+		summary:      "show the latest quotes and what to make of them",
+		needsSymbols: true,
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			c, b := cfg.criteria(), cfg.Broker
+			c.register(fs)
+			b.register(fs)
+			return func(e *env) bool {
+				if !valid(c, &b) {
+					return false
+				}
+				return calc(e.ctx, e.src, e.symbols, c, &b, e.out)
+			}
+		},
+	},
+	{
+		name:         "hist",
+		args:         "[-from date] [-to date] symbol...",
+		summary:      "show the daily history",
+		needsSymbols: true,
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			from, to := dateFlags(fs)
+			return func(e *env) bool {
+				start, end, ok := dateRange(*from, *to)
+				return ok && hist(e.ctx, e.src, e.symbols, start, end, e.out)
+			}
+		},
+	},
+	{
+		name:         "divs",
+		args:         "[-from date] [-to date] symbol...",
+		summary:      "show the dividends that were paid out",
+		needsSymbols: true,
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			from, to := dateFlags(fs)
+			return func(e *env) bool {
+				start, end, ok := dateRange(*from, *to)
+				return ok && divhist(e.ctx, e.src, e.symbols, start, end, e.out)
+			}
+		},
+	},
+	{
+		name:         "screen",
+		args:         "[criteria] symbol...",
+		summary:      "only show the symbols that meet the criteria",
+		needsSymbols: true,
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			c := cfg.criteria()
+			c.register(fs)
+			fs.BoolVar(&c.aboveMa200, "ma200", c.aboveMa200, "only symbols trading above their 200 day average")
+			return func(e *env) bool {
+				return valid(c) && screen(e.ctx, e.src, e.symbols, c, e.out)
+			}
+		},
+	},
+	{
+		name:    "cache",
+		summary: "list what's in the cache, without fetching anything",
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			return func(e *env) bool {
+				return cached(e.src, e.out)
+			}
+		},
+	},
+	{
+		name:    "config",
+		summary: "check the configuration file and show what's in it",
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			return func(e *env) bool {
+				return showConfig(cfg, e.out)
+			}
+		},
+	},
+	{
+		name:    "version",
+		summary: "show the version",
+		setup: func(fs *flag.FlagSet, cfg *config) func(e *env) bool {
+			return func(e *env) bool {
+				version := fmt.Sprintf("%v.%v.%v", MAJ_VERSION, MIN_VERSION, MIC_VERSION)
+				if e.out.structured() {
+					r := record{{"Version", version}}
+					return printed(e.out.emit(r, r, []record{r}))
+				}
+				fmt.Println("gofinance", version)
+				return true
+			}
+		},
+	},
+}
+
+func (c *criteria) register(fs *flag.FlagSet) {
+	fs.Float64Var(&c.minYield, "min-yield", c.minYield, "minimum dividend yield, in %")
+	fs.Float64Var(&c.maxPe, "max-pe", c.maxPe, "maximum P/E ratio, 0 for any")
+	fs.Float64Var(&c.maxSpread, "max-spread", c.maxSpread, "maximum bid/ask spread, in %, 0 for any")
+}
+
+func (b *broker) register(fs *flag.FlagSet) {
+	fs.Float64Var(&b.Fixed, "tx-fixed", b.Fixed, "the fixed cost of a transaction")
+	fs.Float64Var(&b.Percent, "tx-percent", b.Percent, "the cost of a transaction, in % of the amount")
+	fs.Float64Var(&b.Minimum, "tx-min", b.Minimum, "the minimum cost of a transaction")
+	fs.Float64Var(&b.Desired, "tx-desired", b.Desired, "the transaction cost to stay below, in % of the total")
+}
+
+/* flags can undo what the validation of the configuration made sure of */
+func valid(vs ...interface{ validate() error }) bool {
+	for _, v := range vs {
+		if err := v.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, "gofinance:", err)
+			return false
+		}
+	}
+	return true
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+const (
+	EXIT_OK = iota
+	EXIT_FAILURE
+	EXIT_USAGE
+)
+
+/* parses the command line and runs the command, returns the exit code */
+func run(args []string) int {
+	path, explicit := configFlag(args)
+	if !explicit {
+		path = ConfigPath()
+	}
+	cfg, err := loadConfig(path, explicit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gofinance: bad configuration,", err)
+		return EXIT_USAGE
+	}
+
+	o := defaultOptions(cfg)
+	o.config = path
+
+	global := flag.NewFlagSet("gofinance", flag.ContinueOnError)
+	o.register(global)
+	global.Usage = func() { usage(global) }
+	if err := global.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	if global.NArg() == 0 || global.Arg(0) == "help" {
+		usage(global)
+		if global.NArg() == 0 {
+			return EXIT_USAGE
+		}
+		return EXIT_OK
+	}
+
+	cmd := findCommand(global.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "gofinance: unknown command '%v'\n", global.Arg(0))
+		usage(global)
+		return EXIT_USAGE
+	}
+
+	fs := flag.NewFlagSet("gofinance "+cmd.name, flag.ContinueOnError)
+	o.register(fs)
+	runCmd := cmd.setup(fs, cfg)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gofinance %v %v\n\n%v\n\noptions:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(global.Args()[1:]); err != nil {
+		return EXIT_USAGE
+	}
+
+	if !valid(o) {
+		return EXIT_USAGE
+	}
+
+	o.setVerbosity()
+	setColor(o.color)
+	vprintf("welcome to gofinance %v.%v.%v\n", MAJ_VERSION, MIN_VERSION, MIC_VERSION)
+
+	symbols, err := o.symbols(fs.Args(), cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gofinance: could not read the symbols,", err)
+		return EXIT_FAILURE
+	}
+	if cmd.needsSymbols && len(symbols) == 0 {
+		symbols = cfg.Watchlists["default"]
+	}
+	if cmd.needsSymbols && len(symbols) == 0 {
+		fmt.Fprintf(os.Stderr, "gofinance: %v needs at least one symbol\n", cmd.name)
+		fs.Usage()
+		return EXIT_USAGE
+	}
+
+	src, err := o.newSource(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gofinance:", err)
+		return EXIT_USAGE
+	}
+
+	if !o.nocache {
+		cache, err := newCache(src, o)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: could not initialize cache (%v), going to use pure source\n", err)
+		} else {
+			defer cache.Close()
+			src = cache
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	out := &output{format: o.format, w: os.Stdout}
+	if !runCmd(&env{ctx: ctx, src: src, symbols: symbols, out: out}) {
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+func usage(global *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage: gofinance [options] command [options] [symbols]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\noptions:")
+	global.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\nwithout symbols, the \"default\" watchlist of the configuration is used")
+	fmt.Fprintln(os.Stderr, "see gofinance command -h for the options of a command")
+}
+
+const FmtDate = "2006-01-02"
+
+func dateFlags(fs *flag.FlagSet) (from, to *string) {
+	from = fs.String("from", "", "the first day, as "+FmtDate)
+	to = fs.String("to", "", "the last day, as "+FmtDate+" (default today)")
+	return from, to
+}
+
+/* a zero start means no limits */
+func dateRange(from, to string) (start, end time.Time, ok bool) {
+	if from == "" {
+		if to != "" {
+			fmt.Fprintln(os.Stderr, "gofinance: -to needs -from")
+			return start, end, false
+		}
+		return start, end, true
+	}
+
+	start, err := time.ParseInLocation(FmtDate, from, time.Local)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gofinance: bad -from date,", err)
+		return start, end, false
+	}
+
+	end = time.Now()
+	if to != "" {
+		if end, err = time.ParseInLocation(FmtDate, to, time.Local); err != nil {
+			fmt.Fprintln(os.Stderr, "gofinance: bad -to date,", err)
+			return start, end, false
+		}
+	}
+	return start, end, true
+}
+
+var VERBOSITY = 0
+
+func vprintln(a ...interface{}) (int, error) {
+	if VERBOSITY > 0 {
+		return fmt.Fprintln(os.Stderr, a...)
+	}
+
+	return 0, nil
+}
+
+func vprintf(format string, a ...interface{}) (int, error) {
+	if VERBOSITY > 0 {
+		return fmt.Fprintf(os.Stderr, format, a...)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		path     string
+		explicit bool
+	}{
+		{nil, "", false},
+		{[]string{"quote", "AAPL"}, "", false},
+		{[]string{"-config", "x.json", "quote"}, "x.json", true},
+		{[]string{"--config", "x.json", "quote"}, "x.json", true},
+		{[]string{"-config=x.json", "quote"}, "x.json", true},
+		{[]string{"--config=x.json", "quote"}, "x.json", true},
+		{[]string{"quote", "-v", "1", "--config=x.json"}, "x.json", true},
+		/* without a value it's up to the flags to complain */
+		{[]string{"quote", "-config"}, "", false},
+		/* after -- it's a symbol */
+		{[]string{"quote", "--", "-config", "x.json"}, "", false},
+		{[]string{"quote", "--", "--config=x.json"}, "", false},
+	}
+
+	for _, test := range tests {
+		path, explicit := configFlag(test.args)
+		if path != test.path || explicit != test.explicit {
+			t.Errorf("%q: got %q, %v, want %q, %v", test.args, path, explicit, test.path, test.explicit)
+		}
+	}
+}
+
+func TestReadSymbols(t *testing.T) {
+	in := `# the banks
+KBC.BR  ACKB.BR # and the insurer
+	AAPL
+
+#MSFT
+VEUR.AS#no space needed
+`
+	symbols, err := readSymbols(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"KBC.BR", "ACKB.BR", "AAPL", "VEUR.AS"}
+	if !reflect.DeepEqual(symbols, want) {
+		t.Errorf("got %q, want %q", symbols, want)
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	day := func(s string) time.Time {
+		t, _ := time.ParseInLocation(FmtDate, s, time.Local)
+		return t
+	}
+
+	tests := []struct {
+		from, to   string
+		start, end time.Time
+		ok         bool
+	}{
+		{"", "", time.Time{}, time.Time{}, true},
+		{"2020-01-02", "2020-03-04", day("2020-01-02"), day("2020-03-04"), true},
+		{"", "2020-03-04", time.Time{}, time.Time{}, false},
+		{"2020-13-01", "", time.Time{}, time.Time{}, false},
+		{"2020-01-02", "yesterday", time.Time{}, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		start, end, ok := dateRange(test.from, test.to)
+		if ok != test.ok {
+			t.Errorf("%q-%q: got ok %v", test.from, test.to, ok)
+			continue
+		}
+		if ok && (!start.Equal(test.start) || !end.Equal(test.end)) {
+			t.Errorf("%q-%q: got %v-%v, want %v-%v", test.from, test.to, start, end, test.start, test.end)
+		}
+	}
+
+	/* the end defaults to now */
+	start, end, ok := dateRange("2020-01-02", "")
+	if !ok || !start.Equal(day("2020-01-02")) || time.Since(end) > time.Minute {
+		t.Errorf("got %v-%v, %v", start, end, ok)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	/* no configuration file, no cache */
+	t.Setenv("GOFINANCE_DIR", t.TempDir())
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-nocache", "config"}, EXIT_OK},
+		{[]string{"-nocache", "-quote-expiry", "-1m", "config"}, EXIT_USAGE},
+		{[]string{"-nocache", "config", "-quote-expiry=-1m"}, EXIT_USAGE},
+		{[]string{"-nocache", "-timeout", "0s", "config"}, EXIT_USAGE},
+		{[]string{"-nocache", "-format", "xml", "config"}, EXIT_USAGE},
+	}
+
+	for _, test := range tests {
+		if code := run(test.args); code != test.code {
+			t.Errorf("%q: exited with %v, want %v", test.args, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+const CONFIG_FILENAME = "config.json"
+
+/* the configuration file, ~/.gofinance/config.json by default. Everything
+ * in it is optional, and everything can be overridden by flags. An
+ * example:
+ *
+ *     {
+ *         "watchlists": {
+ *             "default": ["VEUR.AS", "BELG.BR", "AAPL"],
+ *             "banks":   ["KBC.BR", "ACKB.BR"]
+ *         },
+ *         "broker":     {"fixed": 7.5, "percent": 0.1, "minimum": 9.75, "desired": 1},
+ *         "thresholds": {"minYield": 3, "maxSpread": 0.5, "maxPe": 20},
+ *         "sources":    ["bloomberg", "yahoo"],
+ *         "precedence": {"Volume": ["yahoo"]},
+ *         "cache":      {"quoteExpiry": "10m", "staleOnError": false}
+ *     }
+ *
+ * Percentages are written as such: 1 means 1%. */
+type config struct {
+	/* named lists of symbols, "default" is used when no symbols are given */
+	Watchlists map[string][]string `json:"watchlists"`
+
+	Broker     broker     `json:"broker"`
+	Thresholds thresholds `json:"thresholds"`
+
+	/* which sources to use, they're combined if there are several */
+	Sources []string `json:"sources"`
+
+	/* per field of fquery.Quote, which sources get to fill it in first */
+	Precedence map[string][]string `json:"precedence"`
+
+	Cache cacheConfig `json:"cache"`
+}
+
+/* what a transaction costs: a fixed amount plus a percentage of the
+ * amount traded, but at least the minimum */
+type broker struct {
+	Fixed   float64 `json:"fixed"`
+	Percent float64 `json:"percent"`
+	Minimum float64 `json:"minimum"`
+
+	/* the costs one is willing to pay, as a percentage of the total */
+	Desired float64 `json:"desired"`
+}
+
+/* what calc colours by and screen filters on, see criteria */
+type thresholds struct {
+	MinYield   float64 `json:"minYield"`
+	MaxSpread  float64 `json:"maxSpread"`
+	MaxPe      float64 `json:"maxPe"`
+	AboveMa200 bool    `json:"aboveMa200"`
+}
+
+type cacheConfig struct {
+	Disabled      bool     `json:"disabled"`
+	Path          string   `json:"path"`
+	QuoteExpiry   duration `json:"quoteExpiry"`
+	StaleOnError  bool     `json:"staleOnError"`
+	KeepSnapshots bool     `json:"keepSnapshots"`
+}
+
+/* a time.Duration that's written like "5m" */
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations are written as strings, like \"5m\": %v", err)
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(dur)
+	return nil
+}
+
+/* what you get without a configuration file, these used to be constants */
+func defaultConfig() *config {
+	return &config{
+		Broker:     broker{Fixed: 9.75, Desired: 1},
+		Thresholds: thresholds{MinYield: 2.5, MaxSpread: 1},
+		Sources:    []string{"bloomberg"},
+		Cache: cacheConfig{
+			QuoteExpiry:  duration(5 * time.Minute),
+			StaleOnError: true,
+		},
+	}
+}
+
+func ConfigPath() string {
+	return ConfigDir() + "/" + CONFIG_FILENAME
+}
+
+/* reads the configuration at path on top of the defaults, a missing file
+ * is fine unless it was asked for explicitly */
+func loadConfig(path string, explicit bool) (*config, error) {
+	cfg := defaultConfig()
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		vprintln("no configuration file at", path, ", using the defaults")
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *config) validate() error {
+	for name, symbols := range cfg.Watchlists {
+		if len(symbols) == 0 {
+			return fmt.Errorf("watchlist '%v' is empty", name)
+		}
+	}
+
+	if err := cfg.Broker.validate(); err != nil {
+		return err
+	}
+	if err := cfg.criteria().validate(); err != nil {
+		return err
+	}
+
+	if len(cfg.Sources) == 0 {
+		return errors.New("at least one source is needed")
+	}
+	for _, name := range cfg.Sources {
+		if _, ok := sources[name]; !ok {
+			return fmt.Errorf("unknown source '%v', choose from %v", name, strings.Join(sourceNames(), ", "))
+		}
+	}
+
+	for field, names := range cfg.Precedence {
+		if _, ok := reflect.TypeOf(fquery.Quote{}).FieldByName(field); !ok {
+			return fmt.Errorf("precedence: quotes have no field '%v'", field)
+		}
+		for _, name := range names {
+			if _, ok := sources[name]; !ok {
+				return fmt.Errorf("precedence of %v: unknown source '%v'", field, name)
+			}
+		}
+	}
+
+	if cfg.Cache.QuoteExpiry < 0 {
+		return errors.New("the quote expiry can't be negative")
+	}
+	return nil
+}
+
+func (b *broker) validate() error {
+	switch {
+	case b.Fixed < 0 || b.Percent < 0 || b.Minimum < 0:
+		return errors.New("broker costs can't be negative")
+	case b.Desired <= 0 || b.Desired >= 100:
+		return errors.New("the desired transaction cost has to be between 0 and 100%")
+	case b.Percent*(1-b.Desired/100) >= b.Desired:
+		return fmt.Errorf("the broker takes %v%%, a transaction cost of %v%% can't be reached", b.Percent, b.Desired)
+	}
+	return nil
+}
+
+/* gives you the number of shares to buy if you want the transaction cost
+ * to be less than the desired percentage of the total (0.5% is
+ * fantastic, 1% is ok, for example) */
+func (b *broker) sharesToBuy(price float64) float64 {
+	d, p := b.Desired/100, b.Percent/100
+
+	/* cost / (amount + cost) <= d, for both parts of the cost */
+	amount := math.Max(
+		b.Fixed*(1-d)/(d-p*(1-d)),
+		b.Minimum*(1-d)/d)
+	return math.Ceil(amount / price)
+}
+
+/* a fresh copy of the thresholds, for the flags to override */
+func (cfg *config) criteria() *criteria {
+	t := cfg.Thresholds
+	return &criteria{
+		minYield:   t.MinYield,
+		maxSpread:  t.MaxSpread,
+		maxPe:      t.MaxPe,
+		aboveMa200: t.AboveMa200,
+	}
+}
+
+/* the sources with the given names, combined into one if there are
+ * several, with the precedence of the configuration */
+func (cfg *config) newSource(names []string) (fquery.Source, error) {
+	var (
+		srcs   []fquery.Source
+		byName = make(map[string]string)
+	)
+	for _, name := range names {
+		mk, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown source '%v', choose from %v", name, strings.Join(sourceNames(), ", "))
+		}
+		src := mk()
+		srcs = append(srcs, src)
+		byName[name] = src.String()
+	}
+
+	if len(srcs) == 1 {
+		return srcs[0], nil
+	}
+
+	c := fquery.NewComposite(srcs...)
+	for field, order := range cfg.Precedence {
+		/* sources that aren't used are skipped */
+		var used []string
+		for _, name := range order {
+			if s, ok := byName[name]; ok {
+				used = append(used, s)
+			}
+		}
+		if len(used) == 0 {
+			continue
+		}
+		if err := c.SetPrecedence(field, used...); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+/* the symbols of a watchlist */
+func (cfg *config) watchlist(name string) ([]string, error) {
+	symbols, ok := cfg.Watchlists[name]
+	if !ok {
+		return nil, fmt.Errorf("there is no watchlist '%v' in the configuration", name)
+	}
+	return symbols, nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+/* prints the configuration as it's used, defaults included. It doesn't
+ * fit in rows, JSON is the only other format. */
+func showConfig(cfg *config, out *output) bool {
+	switch out.format {
+	case FORMAT_TEXT:
+	case FORMAT_JSON:
+		enc := json.NewEncoder(out.w)
+		enc.SetIndent("", "  ")
+		return printed(enc.Encode(cfg))
+	default:
+		fmt.Fprintf(os.Stderr, "gofinance: the configuration can't be printed as %v\n", out.format)
+		return false
+	}
+
+	names := make([]string, 0, len(cfg.Watchlists))
+	for name := range cfg.Watchlists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("watchlists:")
+	for _, name := range names {
+		fmt.Printf("  %-10v %v\n", name, strings.Join(cfg.Watchlists[name], " "))
+	}
+
+	b, t := cfg.Broker, cfg.Thresholds
+	fmt.Printf("broker: %v fixed + %v%%, at least %v, aiming for %v%% of the total\n",
+		b.Fixed, b.Percent, b.Minimum, b.Desired)
+	fmt.Printf("thresholds: yield >= %v%%, spread <= %v%%, P/E <= %v, above the 200 day average: %v\n",
+		t.MinYield, t.MaxSpread, t.MaxPe, t.AboveMa200)
+	fmt.Println("sources:", strings.Join(cfg.Sources, ", "))
+	for field, order := range cfg.Precedence {
+		fmt.Printf("  %v from %v first\n", field, strings.Join(order, ", "))
+	}
+
+	c := cfg.Cache
+	fmt.Printf("cache: disabled %v, quote expiry %v, stale on error %v, keep snapshots %v\n",
+		c.Disabled, time.Duration(c.QuoteExpiry), c.StaleOnError, c.KeepSnapshots)
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string /* part of the error, empty if there shouldn't be one */
+	}{
+		{`{}`, ""},
+		{`{"watchlists": {"banks": ["KBC.BR"]}, "sources": ["bloomberg", "yahoo"], "precedence": {"Volume": ["yahoo"]}}`, ""},
+
+		/* a typo shouldn't go unnoticed */
+		{`{"source": ["yahoo"]}`, `unknown field "source"`},
+		{`{"cache": {"quoteExpiry": "1m", "stale": true}}`, `unknown field "stale"`},
+
+		{`{"watchlists": {"banks": []}}`, "watchlist 'banks' is empty"},
+		{`{"sources": []}`, "at least one source"},
+		{`{"sources": ["nasdaq"]}`, "unknown source 'nasdaq'"},
+		{`{"precedence": {"Volumes": ["yahoo"]}}`, "no field 'Volumes'"},
+		{`{"precedence": {"Volume": ["nasdaq"]}}`, "unknown source 'nasdaq'"},
+
+		{`{"broker": {"fixed": -1, "desired": 1}}`, "can't be negative"},
+		{`{"broker": {"fixed": 5, "desired": 0}}`, "between 0 and 100%"},
+		{`{"broker": {"fixed": 5, "desired": 100}}`, "between 0 and 100%"},
+		{`{"broker": {"percent": 2, "desired": 1}}`, "can't be reached"},
+		{`{"thresholds": {"maxPe": -1}}`, "can't be negative"},
+
+		{`{"cache": {"quoteExpiry": "ten minutes"}}`, "invalid duration"},
+		{`{"cache": {"quoteExpiry": 600}}`, "written as strings"},
+		{`{"cache": {"quoteExpiry": "-1m"}}`, "quote expiry can't be negative"},
+	}
+
+	dir := t.TempDir()
+	for i, test := range tests {
+		path := filepath.Join(dir, CONFIG_FILENAME)
+		if err := os.WriteFile(path, []byte(test.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadConfig(path, true)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%v: %v: %v", i, test.json, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%v: %v: got %v, want an error about %q", i, test.json, err, test.err)
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CONFIG_FILENAME)
+
+	cfg, err := loadConfig(path, false)
+	if err != nil || cfg.Sources[0] != defaultConfig().Sources[0] {
+		t.Errorf("got %+v, %v, want the defaults", cfg, err)
+	}
+	if _, err := loadConfig(path, true); err == nil {
+		t.Error("a configuration that was asked for has to be there")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CONFIG_FILENAME)
+	err := os.WriteFile(path, []byte(`{"cache": {"quoteExpiry": "1h30m"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Duration(cfg.Cache.QuoteExpiry); d != 90*time.Minute {
+		t.Errorf("got %v", d)
+	}
+
+	/* and back */
+	b, err := cfg.Cache.QuoteExpiry.MarshalJSON()
+	if err != nil || string(b) != `"1h30m0s"` {
+		t.Errorf("got %s, %v", b, err)
+	}
+}
+
+func TestSharesToBuy(t *testing.T) {
+	tests := []struct {
+		b      broker
+		price  float64
+		shares float64
+	}{
+		/* 97 * 10 costs 9.75 / 979.75 = 0.995%, 96 would be 1.005% */
+		{broker{Fixed: 9.75, Desired: 1}, 10, 97},
+		/* the fixed part is what counts here, 26 * 50 costs 26.5 */
+		{broker{Fixed: 20, Percent: 0.5, Minimum: 5, Desired: 2}, 50, 26},
+		/* and the minimum here, 10 * 100 costs 9.75 */
+		{broker{Fixed: 1, Percent: 0.1, Minimum: 9.75, Desired: 1}, 100, 10},
+		/* a single share does */
+		{broker{Fixed: 1, Desired: 1}, 1000, 1},
+	}
+
+	/* the part of the total that goes to the broker, in % */
+	costs := func(b broker, amount float64) float64 {
+		cost := math.Max(b.Fixed+b.Percent/100*amount, b.Minimum)
+		return cost / (amount + cost) * 100
+	}
+
+	for _, test := range tests {
+		b := test.b
+		if err := b.validate(); err != nil {
+			t.Fatalf("%+v: %v", b, err)
+		}
+
+		shares := b.sharesToBuy(test.price)
+		if shares != test.shares {
+			t.Errorf("%+v at %v: got %v shares, want %v", b, test.price, shares, test.shares)
+		}
+		if c := costs(b, shares*test.price); c > b.Desired {
+			t.Errorf("%+v: %v shares cost %.3f%%", b, shares, c)
+		}
+		if c := costs(b, (shares-1)*test.price); shares > 1 && c <= b.Desired {
+			t.Errorf("%+v: %v shares would do too, at %.3f%%", b, shares-1, c)
+		}
+	}
+}
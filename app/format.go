@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+	"github.com/mgutz/ansi"
+)
+
+/* how the commands print what they fetched: the text is meant for people,
+ * the rest for other programs */
+const (
+	FORMAT_TEXT  = "text"
+	FORMAT_TABLE = "table"
+	FORMAT_CSV   = "csv"
+	FORMAT_JSON  = "json"
+)
+
+var formats = []string{FORMAT_TEXT, FORMAT_TABLE, FORMAT_CSV, FORMAT_JSON}
+
+func validFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+/* colour is for terminals only, and only if nobody asked otherwise
+ * (https://no-color.org) */
+func colorByDefault() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func setColor(on bool) {
+	ansi.DisableColors(!on)
+}
+
+/* the fields of a struct in the order they're declared, so that every
+ * format lists them the same way. The values are converted to what they
+ * look like in JSON and CSV:
+ *
+ *   - times are RFC 3339, days (util.YearMonthDay) 2006-01-02, and zero
+ *     times are left out (null)
+ *   - durations are in seconds
+ *   - ranges of days are 2006-01-02/2006-01-02
+ *   - slices of structs become lists of records */
+type record []field
+
+type field struct {
+	name  string
+	value interface{}
+}
+
+func (r record) names() []string {
+	names := make([]string, len(r))
+	for i, f := range r {
+		names[i] = f.name
+	}
+	return names
+}
+
+/* the same record with only the named fields, in the order given */
+func (r record) only(names []string) record {
+	res := make(record, 0, len(names))
+	for _, name := range names {
+		for _, f := range r {
+			if f.name == name {
+				res = append(res, f)
+				break
+			}
+		}
+	}
+	return res
+}
+
+/* an object with the fields in order, encoding/json would sort them */
+func (r record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func toRecord(v interface{}) record {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	r := make(record, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).PkgPath != "" {
+			continue
+		}
+		r = append(r, field{rt.Field(i).Name, toValue(rv.Field(i))})
+	}
+	return r
+}
+
+func toValue(v reflect.Value) interface{} {
+	switch x := v.Interface().(type) {
+	case time.Time:
+		if x.IsZero() {
+			return nil
+		}
+		return x.Format(time.RFC3339)
+	case util.YearMonthDay:
+		return x.GetTime().Format(FmtDate)
+	case time.Duration:
+		return x.Seconds()
+	case fquery.DateRange:
+		return x.From.Format(FmtDate) + "/" + x.To.Format(FmtDate)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return toRecord(v.Interface())
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = toValue(v.Index(i))
+		}
+		return list
+	case reflect.Float32, reflect.Float64:
+		/* JSON has no NaN or infinity */
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil
+		}
+	}
+	return v.Interface()
+}
+
+/* a value as a CSV or table cell, lists are separated by spaces */
+func cell(v interface{}, table bool) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		if table {
+			x = math.Round(x*1e4) / 1e4
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case []interface{}:
+		cells := make([]string, len(x))
+		for i, y := range x {
+			cells[i] = cell(y, table)
+		}
+		return strings.Join(cells, " ")
+	case record:
+		cells := make([]string, len(x))
+		for i, f := range x {
+			cells[i] = cell(f.value, table)
+		}
+		return strings.Join(cells, "/")
+	}
+	return fmt.Sprint(v)
+}
+
+/* writes what the commands fetched, in anything but FORMAT_TEXT */
+type output struct {
+	format string
+	w      io.Writer
+}
+
+func (o *output) structured() bool {
+	return o.format != FORMAT_TEXT
+}
+
+/* the columns of a quote in a table, all of them wouldn't fit */
+var quoteColumns = []string{
+	"Symbol", "Name", "LastTradePrice", "PreviousClose", "Bid", "Ask",
+	"DividendYield", "PeRatio", "Ma50", "Ma200", "Updated",
+}
+
+/* JSON gets a list of quotes, CSV and tables a quote per row */
+func (o *output) quotes(quotes []fquery.Quote) error {
+	rows := make([]record, len(quotes))
+	for i := range quotes {
+		rows[i] = toRecord(&quotes[i])
+	}
+
+	if o.format == FORMAT_TABLE {
+		for i := range rows {
+			rows[i] = rows[i].only(quoteColumns)
+		}
+		return o.emit(rows, toRecord(fquery.Quote{}).only(quoteColumns), rows)
+	}
+	return o.emit(rows, toRecord(fquery.Quote{}), rows)
+}
+
+/* JSON gets a list of histories, CSV and tables a day per row */
+func (o *output) hists(hists []fquery.Hist) error {
+	var (
+		list = make([]record, len(hists))
+		rows []record
+	)
+	for i := range hists {
+		list[i] = toRecord(&hists[i])
+		for j := range hists[i].Entries {
+			rows = append(rows, entryRecord(hists[i].Symbol, &hists[i].Entries[j]))
+		}
+	}
+	return o.emit(list, entryRecord("", &fquery.HistEntry{}), rows)
+}
+
+/* JSON gets a list of dividend histories, CSV and tables a payout per
+ * row */
+func (o *output) dividends(divs []fquery.DividendHist) error {
+	var (
+		list = make([]record, len(divs))
+		rows []record
+	)
+	for i := range divs {
+		list[i] = toRecord(&divs[i])
+		for j := range divs[i].Dividends {
+			rows = append(rows, entryRecord(divs[i].Symbol, &divs[i].Dividends[j]))
+		}
+	}
+	return o.emit(list, entryRecord("", &fquery.DividendEntry{}), rows)
+}
+
+/* the entry of a history, with the symbol it belongs to in front */
+func entryRecord(symbol string, entry interface{}) record {
+	return append(record{{"Symbol", symbol}}, toRecord(entry)...)
+}
+
+func (o *output) contents(contents []fquery.CachedSymbol) error {
+	rows := make([]record, len(contents))
+	for i := range contents {
+		rows[i] = toRecord(&contents[i])
+	}
+	return o.emit(rows, toRecord(fquery.CachedSymbol{}), rows)
+}
+
+/* v is what JSON gets, the rows are for the other formats. The header
+ * comes from proto, so that it's there even without rows. */
+func (o *output) emit(v interface{}, proto record, rows []record) error {
+	switch o.format {
+	case FORMAT_JSON:
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case FORMAT_CSV:
+		w := csv.NewWriter(o.w)
+		w.Write(proto.names())
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, f := range row {
+				cells[i] = cell(f.value, false)
+			}
+			w.Write(cells)
+		}
+		w.Flush()
+		return w.Error()
+
+	case FORMAT_TABLE:
+		w := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(proto.names(), "\t"))
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, f := range row {
+				cells[i] = cell(f.value, true)
+			}
+			fmt.Fprintln(w, strings.Join(cells, "\t"))
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("can't print %v", o.format)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+var update = flag.Bool("update", false, "write what's printed to the golden files")
+
+/* prints with every structured format and compares with
+ * testdata/<name>.<format>.golden */
+func golden(t *testing.T, name string, print func(o *output) error) {
+	t.Helper()
+	for _, format := range []string{FORMAT_JSON, FORMAT_CSV, FORMAT_TABLE} {
+		var buf bytes.Buffer
+		if err := print(&output{format: format, w: &buf}); err != nil {
+			t.Errorf("%v as %v: %v", name, format, err)
+			continue
+		}
+
+		path := filepath.Join("testdata", name+"."+format+".golden")
+		if *update {
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%v as %v:\n%s\nwant:\n%s", name, format, buf.Bytes(), want)
+		}
+	}
+}
+
+var cet = time.FixedZone("CEST", 2*60*60)
+
+func day(y int, m time.Month, d int) util.YearMonthDay {
+	return util.YearMonthDay(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+}
+
+func TestFormatQuote(t *testing.T) {
+	quotes := []fquery.Quote{{
+		/* the quotes and the comma need escaping in CSV */
+		Symbol:         "VEUR.AS",
+		Name:           `Vanguard "FTSE" Developed Europe, ETF`,
+		Exchange:       "AEX",
+		Updated:        time.Date(2026, 10, 16, 17, 35, 0, 0, cet),
+		Volume:         123456,
+		DividendYield:  0.0312,
+		PeRatio:        math.NaN(),
+		Bid:            31.45,
+		Ask:            31.5,
+		LastTradePrice: 31.456789,
+		PreviousClose:  31.2,
+		Ma50:           30.1,
+		Ma200:          29.87654321,
+		FundType:       "ETF",
+		Stale:          90 * time.Minute,
+	}, {
+		Symbol: "AAPL",
+	}}
+
+	golden(t, "quote", func(o *output) error { return o.quotes(quotes) })
+}
+
+func TestFormatHist(t *testing.T) {
+	hists := []fquery.Hist{{
+		Symbol: "VEUR.AS",
+		From:   time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+		Entries: []fquery.HistEntry{
+			{Date: day(2026, 10, 15), Open: 31, Close: 31.2, AdjClose: 31.2, High: 31.3, Low: 30.9, Volume: 1000},
+			{Date: day(2026, 10, 16), Open: 31.2, Close: 31.456789, AdjClose: 31.456789, High: 31.5, Low: 31.1, Volume: 2000},
+		},
+		Stale: 2 * 24 * time.Hour,
+	}, {
+		Symbol: "AAPL",
+	}}
+
+	golden(t, "hist", func(o *output) error { return o.hists(hists) })
+}
+
+func TestFormatDividends(t *testing.T) {
+	divs := []fquery.DividendHist{{
+		Symbol: "VEUR.AS",
+		Dividends: []fquery.DividendEntry{
+			{Date: day(2026, 3, 19), Dividends: 0.1234},
+			{Date: day(2026, 6, 18), Dividends: 0.56789},
+		},
+	}, {
+		Symbol: "AAPL",
+	}}
+
+	golden(t, "divs", func(o *output) error { return o.dividends(divs) })
+}
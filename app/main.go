@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/aktau/gofinance/bloomberg"
 	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/indicators"
 	"github.com/aktau/gofinance/sqlitecache"
 	"github.com/aktau/gofinance/util"
-	"math"
+	"github.com/aktau/gofinance/yahoofinance"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
 const (
 	CONFIG_SUBPATH = ".gofinance"
 	DB_FILENAME    = "gofinance.db"
+
+	/* give up on a source if it takes longer than this to answer */
+	FETCH_TIMEOUT = 30 * time.Second
+
+	/* enough calendar days of history for a 200 day moving average */
+	MA_HIST_DAYS = 300
 )
 
 func ConfigDir() string {
@@ -34,63 +44,15 @@ func DbPath() string {
 }
 
 func main() {
-	fmt.Printf("welcome to gofinance %v.%v.%v\n", MAJ_VERSION, MIN_VERSION, MIC_VERSION)
-
-	var src fquery.Source
-	src = bloomberg.New()
-
-	// symbols := []string{
-	// 	"VEUR.AS",
-	// 	"VJPN.AS",
-	// 	"VHYL.AS",
-	// 	"AAPL",
-	// 	"APC.F",
-	// 	"GSZ.PA",
-	// 	"COFB.BR",
-	// 	"BEFB.BR",
-	// 	"GIMB.BR",
-	// 	"ELI.BR",
-	// 	"DELB.BR",
-	// 	"BELG.BR",
-	// 	"TNET.BR",
-	// }
-
-	symbols := []string{
-		"VEUR.AS",
-		"VFEM.AS",
-		"BELG.BR",
-		"UMI.BR",
-		"SOLB.BR",
-		"KBC.BR",
-		"DIE.BR",
-		"DL.BR",
-		"BEKB.BR",
-		"ACKB.BR",
-		"ABI.BR",
-		"EURUSD=X",
-	}
-
-	sqlitecache.VERBOSITY = 0
-	bloomberg.VERBOSITY = 2
-
-	cache, err := newCache(src)
-	if err != nil {
-		fmt.Printf("WARNING: could not initialize cache (%v), going to use pure source\n\t", err)
-	} else {
-		cache.SetQuoteExpiry(5 * time.Minute)
-		defer cache.Close()
-		src = cache
-	}
-
-	// divhist(src)
-	// hist(src, symbols...)
-	calc(src, symbols...)
+	os.Exit(run(os.Args[1:]))
 }
 
-/* attempts to create a cached version of the passed-in source */
-func newCache(src fquery.Source) (fquery.Cache, error) {
+/* attempts to create a cached version of the passed-in source, stored
+ * at the path of the options */
+func newCache(src fquery.Source, o *options) (fquery.Cache, error) {
+	dbpath := o.db
+
 	/* get the path and try to create it if it doesn't exist */
-	dbpath := DbPath()
 	dbdir := filepath.Dir(dbpath)
 	if err := os.MkdirAll(dbdir, 0755); err != nil {
 		return nil, err
@@ -102,18 +64,88 @@ func newCache(src fquery.Source) (fquery.Cache, error) {
 		return nil, err
 	}
 
-	fmt.Println("cache initialized, db located at:", dbpath)
+	cache.SetStaleOnError(o.staleOnError)
+	cache.SetKeepSnapshots(o.keepSnapshots)
+	cache.SetQuoteExpiry(o.quoteExpiry)
+
+	vprintln("cache initialized, db located at:", dbpath)
 	return cache, nil
 }
 
-func divhist(src fquery.Source) {
-	res, err := src.DividendHist([]string{"BELG.BR", "TNET.BR"})
+func cached(src fquery.Source, out *output) bool {
+	c, ok := src.(fquery.Inspectable)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "gofinance: %v can't list its contents\n", src)
+		return false
+	}
+
+	contents, err := c.Contents()
 	if err != nil {
-		fmt.Println("gofinance: could not fetch history, ", err)
-		return
+		fmt.Fprintln(os.Stderr, "gofinance: could not list the cache, ", err)
+		return false
+	}
+	if out.structured() {
+		return printed(out.contents(contents))
+	}
+
+	fmt.Printf("%-10v %-12v %-8v %-30v %v\n", "symbol", "quote age", "entries", "history", "dividends")
+	for _, s := range contents {
+		age := "-"
+		if s.HasQuote() {
+			age = s.QuoteAge().Truncate(time.Second).String()
+		}
+
+		ranges := make([]string, 0, len(s.Hist))
+		for _, r := range s.Hist {
+			ranges = append(ranges, r.From.Format("02/01/2006")+"-"+r.To.Format("02/01/2006"))
+		}
+		if len(ranges) == 0 {
+			ranges = append(ranges, "-")
+		}
+
+		fmt.Printf("%-10v %-12v %-8v %-30v %v\n", s.Symbol, age, s.HistEntries,
+			strings.Join(ranges, ", "), s.Dividends)
+	}
+	return true
+}
+
+/* start and end limit the history if they're not zero */
+func divhist(ctx context.Context, src fquery.Source, symbols []string, start, end time.Time, out *output) bool {
+	action := fquery.ActionDividendHist
+	if !start.IsZero() {
+		action = fquery.ActionDividendHistLimit
+	}
+	if !src.Capabilities().Supports(action) {
+		fmt.Fprintf(os.Stderr, "gofinance: %v can't fetch dividend history\n", src)
+		return false
+	}
+
+	var (
+		res map[string]fquery.DividendHist
+		err error
+	)
+	if start.IsZero() {
+		res, err = fquery.WithContext(src).DividendHistContext(ctx, symbols)
+	} else {
+		res, err = fquery.WithContext(src).DividendHistLimitContext(ctx, symbols, start, end)
+	}
+	if err != nil {
+		reportErrors(err)
+		if len(res) == 0 {
+			return false
+		}
 	}
 
-	fmt.Printf("succesfully fetched %v symbols' dividend history\n", len(res))
+	if out.structured() {
+		divs := make([]fquery.DividendHist, 0, len(res))
+		for _, d := range res {
+			divs = append(divs, d)
+		}
+		sort.Slice(divs, bySymbols(symbols, func(i int) string { return divs[i].Symbol }))
+		return printed(out.dividends(divs))
+	}
+
+	fmt.Printf("successfully fetched %v symbols' dividend history\n", len(res))
 
 	for symb, hist := range res {
 		fmt.Println(symb)
@@ -123,13 +155,43 @@ func divhist(src fquery.Source) {
 			fmt.Println("row:", row.Date.GetTime().Format("02-01-2006"), row.Dividends)
 		}
 	}
+	return true
 }
 
-func hist(src fquery.Source, symbols ...string) {
-	res, err := src.Hist(symbols)
+/* start and end limit the history if they're not zero */
+func hist(ctx context.Context, src fquery.Source, symbols []string, start, end time.Time, out *output) bool {
+	action := fquery.ActionHist
+	if !start.IsZero() {
+		action = fquery.ActionHistLimit
+	}
+	if !src.Capabilities().Supports(action) {
+		fmt.Fprintf(os.Stderr, "gofinance: %v can't fetch history\n", src)
+		return false
+	}
+
+	var (
+		res map[string]fquery.Hist
+		err error
+	)
+	if start.IsZero() {
+		res, err = fquery.WithContext(src).HistContext(ctx, symbols)
+	} else {
+		res, err = fquery.WithContext(src).HistLimitContext(ctx, symbols, start, end)
+	}
 	if err != nil {
-		fmt.Println("gofinance: could not fetch history, ", err)
-		return
+		reportErrors(err)
+		if len(res) == 0 {
+			return false
+		}
+	}
+
+	if out.structured() {
+		hists := make([]fquery.Hist, 0, len(res))
+		for _, h := range res {
+			hists = append(hists, h)
+		}
+		sort.Slice(hists, bySymbols(symbols, func(i int) string { return hists[i].Symbol }))
+		return printed(out.hists(hists))
 	}
 
 	fmt.Println("Printing history for symbols:", symbols)
@@ -137,39 +199,38 @@ func hist(src fquery.Source, symbols ...string) {
 		fmt.Println(symb)
 		fmt.Println("===========")
 		fmt.Println("Length:", len(hist.Entries))
+		if hist.Stale != 0 {
+			fmt.Println(redu("STALE:"), "could not be updated, last complete", hist.Stale.Round(time.Minute), "ago")
+		}
+		fmt.Printf("%-10v %10v %10v %10v %10v %10v %12v\n", "date", "open", "high", "low", "close", "adj. close", "volume")
 		for _, row := range hist.Entries {
-			t := time.Time(row.Date)
-			fmt.Printf("%v: %v (%v)\n", t.Format("02/01/2006"), row.Close, row)
+			fmt.Printf("%-10v %10.2f %10.2f %10.2f %10.2f %10.2f %12v\n", row.Date.GetTime().Format("02/01/2006"),
+				row.Open, row.High, row.Low, row.Close, row.AdjClose, row.Volume)
 		}
-		fmt.Println("Moving average manual calc:", movingAverage(hist))
+		printIndicators(hist)
 	}
-
-	/* with time limits */
-	// start := time.Date(2009, time.November, 1, 0, 0, 0, 0, time.UTC)
-	// end := time.Date(2011, time.November, 1, 0, 0, 0, 0, time.UTC)
-	// _, err = src.HistLimit([]string{"AAPL", "VEUR.AS", "VJPN.AS"}, start, end)
-	// if err != nil {
-	// 	fmt.Println("gofinance: could not fetch history, ", err)
-	// }
+	return true
 }
 
-func calc(src fquery.Source, symbols ...string) {
-	fmt.Println("requesting information on individual stocks...", symbols)
-	res, err := src.Quote(symbols)
+/* returns false if not a single symbol could be fetched */
+func calc(ctx context.Context, src fquery.Source, symbols []string, c *criteria, b *broker, out *output) bool {
+	vprintln("requesting information on individual stocks...", symbols)
+	res, err := fquery.WithContext(src).QuoteContext(ctx, symbols)
 	if err != nil {
-		fmt.Println("gofinance: could not fetch, ", err)
-		return
+		reportErrors(err)
+		if len(res) == 0 {
+			return false
+		}
+	}
+	fillMovingAverages(ctx, src, res)
+	if out.structured() {
+		return printed(out.quotes(res))
 	}
-
-	desiredTxCostPerc := 0.01
-	txCost := 9.75
-	maxBidAskSpreadPerc := 0.01
-	minDivYield := 0.025
 
 	fmt.Println()
 	for _, r := range res {
 		price := nvl(r.Ask, r.LastTradePrice)
-		amountOfsharesForLowTxCost := sharesToBuy(price, txCost, desiredTxCostPerc)
+		amountOfsharesForLowTxCost := b.sharesToBuy(price)
 
 		upDir := r.LastTradePrice >= r.PreviousClose
 		upVal := r.LastTradePrice - r.PreviousClose
@@ -180,13 +241,17 @@ func calc(src fquery.Source, symbols ...string) {
 			binary(fmt.Sprintf("%+.2f%%", upPerc), upDir),
 			binary(arrow(upDir), upDir),
 			yahoofinance.GenChartUrl(r.Symbol, yahoofinance.Year2, nil))
+		if r.Stale != 0 {
+			fmt.Println(redu("STALE:"), "could not be updated, this quote is", r.Stale.Round(time.Minute), "old")
+		}
 
 		if r.Bid != 0 && r.Ask != 0 {
-			bidAskSpreadPerc := (r.Ask - r.Bid) / r.Bid
-			bidAskPrint := binaryfp(bidAskSpreadPerc*100, bidAskSpreadPerc < maxBidAskSpreadPerc)
+			bidAskSpreadPerc := (r.Ask - r.Bid) / r.Bid * 100
+			lowSpread := c.maxSpread == 0 || bidAskSpreadPerc <= c.maxSpread
+			bidAskPrint := binaryfp(bidAskSpreadPerc, lowSpread)
 			fmt.Printf("bid/ask: %v/%v, spread: %v (%v)\n",
 				numberf(r.Bid), numberf(r.Ask), numberf(r.Ask-r.Bid), bidAskPrint)
-			if bidAskSpreadPerc < maxBidAskSpreadPerc {
+			if lowSpread {
 				fmt.Printf("if you want to buy this stock, place a %v at about %v\n", green("limit order"), greenf((r.Ask+r.Bid)/2))
 			} else {
 				fmt.Println(redu("CAUTION:"), "the spread of this stock is rather high")
@@ -197,16 +262,30 @@ func calc(src fquery.Source, symbols ...string) {
 			numberf(r.PreviousClose), numberf(r.Open), numberf(r.LastTradePrice))
 		fmt.Printf("day low/high: %v/%v (%v)\n", numberf(r.DayLow), numberf(r.DayHigh), numberf(r.DayHigh-r.DayLow))
 		fmt.Printf("year low/high: %v/%v (%v)\n", numberf(r.YearLow), numberf(r.YearHigh), numberf(r.YearHigh-r.YearLow))
+		if r.YearReturn != 0 {
+			fmt.Printf("1 year return: %v\n", binaryfp(r.YearReturn*100, r.YearReturn >= 0))
+		}
 		fmt.Printf("moving avg. 50/200: %v/%v\n", numberf(r.Ma50), numberf(r.Ma200))
-		divYield := binaryfp(r.DividendYield*100, r.DividendYield > minDivYield)
+		if r.IsFund() {
+			fmt.Printf("fund type: %v, nav: %v, expense ratio: %v, total assets: %v\n",
+				r.FundType, numberf(r.Nav), numberfp(r.ExpenseRatio*100), numberf(r.TotalAssets))
+		}
+		divYield := binaryfp(r.DividendYield*100, r.DividendYield*100 >= c.minYield)
 		fmt.Printf("last ex-dividend: %v, div. per share: %v, div. yield: %v,\n earnings per share: %v, dividend payout ratio: %v\n",
 			r.DividendExDate.Format("02/01"), numberf(r.DividendPerShare),
 			divYield, numberf(r.EarningsPerShare), numberf(r.DivPayoutRatio()))
+		if r.DividendGrowth5y != 0 {
+			fmt.Printf("dividend growth (5y): %v\n", binaryfp(r.DividendGrowth5y*100, r.DividendGrowth5y >= 0))
+		}
+		if r.PeRatioEst != 0 || r.EarningsPerShareEst != 0 {
+			fmt.Printf("estimated P/E: %v, estimated earnings per share: %v, P/E relative to index: %v\n",
+				numberf(r.PeRatioEst), numberf(r.EarningsPerShareEst), numberf(r.PeRatioRelToIndex))
+		}
 		fmt.Printf("You would need to buy %v (€ %v) shares of this stock to reach a transaction cost below %v%%\n",
-			greenf(amountOfsharesForLowTxCost), greenf(amountOfsharesForLowTxCost*price), desiredTxCostPerc*100)
+			greenf(amountOfsharesForLowTxCost), greenf(amountOfsharesForLowTxCost*price), b.Desired)
 		if r.PeRatio != 0 {
 			// terminal.Stdout.Colorf("The P/E-ratio is @m%.2f@|, ", r.PeRatio)
-			fmt.Println("The P/E-ratio is %v, ", numberf(r.PeRatio))
+			fmt.Printf("The P/E-ratio is %v, ", numberf(r.PeRatio))
 			switch {
 			case 0 <= r.PeRatio && r.PeRatio <= 10:
 				underv := green("undervalued")
@@ -243,33 +322,193 @@ func calc(src fquery.Source, symbols ...string) {
 
 		fmt.Println("======================")
 	}
+
+	return true
 }
 
-/* gives you the number of shares to buy if you want
- * the transaction cost to be less than a certain percentage
- * (0.5% is fantastic, 1% is ok, for example) */
-func sharesToBuy(price, txCost, desiredTxCostPerc float64) float64 {
-	return math.Ceil((txCost - desiredTxCostPerc*txCost) /
-		(desiredTxCostPerc * price))
+/* what a stock has to live up to, percentages are given as such (2.5
+ * for 2.5%), zero means anything goes */
+type criteria struct {
+	minYield   float64
+	maxPe      float64
+	maxSpread  float64
+	aboveMa200 bool
 }
 
-/* you can get the moving average for 50 and 200 days
- * out of the standard stock quote, but if you need
- * something else, just point it at this function */
-func movingAverage(hist fquery.Hist) float64 {
-	if len(hist.Entries) == 0 {
-		return 0
+func (c *criteria) validate() error {
+	if c.minYield < 0 || c.maxPe < 0 || c.maxSpread < 0 {
+		return errors.New("thresholds can't be negative")
 	}
+	return nil
+}
+
+/* returns why q doesn't meet the criteria, or "" if it does */
+func (c *criteria) reject(q *fquery.Quote) string {
+	switch {
+	case c.minYield != 0 && q.DividendYield*100 < c.minYield:
+		return fmt.Sprintf("dividend yield %.2f%% below %.2f%%", q.DividendYield*100, c.minYield)
+	case c.maxPe != 0 && (q.PeRatio == 0 || q.PeRatio > c.maxPe):
+		return fmt.Sprintf("P/E %.2f above %.2f", q.PeRatio, c.maxPe)
+	case c.maxSpread != 0 && q.Bid != 0 && q.Ask != 0 && (q.Ask-q.Bid)/q.Bid*100 > c.maxSpread:
+		return fmt.Sprintf("spread %.2f%% above %.2f%%", (q.Ask-q.Bid)/q.Bid*100, c.maxSpread)
+	case c.aboveMa200 && (q.Ma200 == 0 || !wouldRichieRichBuy(*q)):
+		return "not above its 200 day average"
+	}
+	return ""
+}
+
+/* lists the symbols that meet the criteria, returns false if not a single
+ * symbol could be fetched */
+func screen(ctx context.Context, src fquery.Source, symbols []string, c *criteria, out *output) bool {
+	res, err := fquery.WithContext(src).QuoteContext(ctx, symbols)
+	if err != nil {
+		reportErrors(err)
+		if len(res) == 0 {
+			return false
+		}
+	}
+	fillMovingAverages(ctx, src, res)
 
-	var sum float64 = 0
-	var count float64 = 0
+	passed := res[:0]
+	for _, q := range res {
+		if why := c.reject(&q); why != "" {
+			vprintln(q.Symbol, "doesn't make the cut:", why)
+			continue
+		}
+		passed = append(passed, q)
+	}
+	if out.structured() {
+		return printed(out.quotes(passed))
+	}
 
-	for _, row := range hist.Entries {
-		sum += row.Close
-		count++
+	fmt.Printf("%-10v %-30v %10v %8v %8v %8v\n", "symbol", "name", "price", "yield", "P/E", "spread")
+	for i := range passed {
+		q := &passed[i]
+
+		spread := "-"
+		if q.Bid != 0 && q.Ask != 0 {
+			spread = fmt.Sprintf("%.2f%%", (q.Ask-q.Bid)/q.Bid*100)
+		}
+		fmt.Printf("%-10v %-30.30v %10.2f %7.2f%% %8.2f %8v\n", q.Symbol, q.Name,
+			nvl(q.LastTradePrice, q.PreviousClose), q.DividendYield*100, q.PeRatio, spread)
 	}
+	return true
+}
 
-	return sum / count
+/* prints which symbols failed and why, grouped per kind of failure so
+ * it's obvious whether it's worth trying again later */
+func reportErrors(err error) {
+	errs, ok := err.(fquery.SymbolErrors)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "gofinance: could not fetch,", err)
+		return
+	}
+
+	byKind := make(map[fquery.ErrorKind][]string)
+	for _, symbol := range errs.Symbols() {
+		kind := errs.Kind(symbol)
+		byKind[kind] = append(byKind[kind], symbol)
+	}
+
+	for _, kind := range []fquery.ErrorKind{
+		fquery.KindUnknownSymbol,
+		fquery.KindNetwork,
+		fquery.KindLayout,
+		fquery.KindCanceled,
+		fquery.KindNotSupported,
+		fquery.KindOther,
+	} {
+		failed, ok := byKind[kind]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "gofinance: could not fetch %v (%v)\n", failed, red(kind.String()))
+		for _, symbol := range failed {
+			fmt.Fprintln(os.Stderr, "\t", errs[symbol].Err)
+		}
+	}
+}
+
+/* reports a failure to print, false if there was one */
+func printed(err error) bool {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gofinance: could not print,", err)
+		return false
+	}
+	return true
+}
+
+/* orders by the position of the symbols in the list they were asked
+ * for in, the ones that aren't in it go last, alphabetically */
+func bySymbols(symbols []string, symbol func(i int) string) func(i, j int) bool {
+	pos := make(map[string]int, len(symbols))
+	for i := len(symbols) - 1; i >= 0; i-- {
+		pos[symbols[i]] = i
+	}
+	rank := func(s string) int {
+		if p, ok := pos[s]; ok {
+			return p
+		}
+		return len(symbols)
+	}
+	return func(i, j int) bool {
+		a, b := symbol(i), symbol(j)
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		return a < b
+	}
+}
+
+/* the latest value of the usual indicators */
+func printIndicators(hist fquery.Hist) {
+	last := func(s indicators.Series) string {
+		if p, ok := s.Last(); ok {
+			return numberf(p.Value)
+		}
+		return "-"
+	}
+
+	macd, signal, _ := indicators.MACD(hist, 12, 26, 9)
+	_, upper, lower := indicators.Bollinger(hist, 20, 2)
+	fmt.Printf("SMA 50/200: %v/%v, EMA 20: %v, RSI 14: %v, ATR 14: %v\n",
+		last(indicators.SMA(hist, 50)), last(indicators.SMA(hist, 200)),
+		last(indicators.EMA(hist, 20)), last(indicators.RSI(hist, 14)), last(indicators.ATR(hist, 14)))
+	fmt.Printf("MACD 12/26/9: %v (signal %v), Bollinger 20/2: %v-%v\n",
+		last(macd), last(signal), last(lower), last(upper))
+}
+
+/* not every source has the 50 and 200 day moving averages (Bloomberg
+ * doesn't), they're calculated from the history if the cache has it.
+ * Nothing is fetched for it. */
+func fillMovingAverages(ctx context.Context, src fquery.Source, quotes []fquery.Quote) {
+	cache, ok := src.(fquery.Cache)
+	if !ok {
+		return
+	}
+
+	end := time.Now()
+	start := end.AddDate(0, 0, -MA_HIST_DAYS)
+
+	var symbols []string
+	for _, q := range quotes {
+		if (q.Ma50 == 0 || q.Ma200 == 0) && cache.HasHist(q.Symbol, &start, &end) {
+			symbols = append(symbols, q.Symbol)
+		}
+	}
+	if len(symbols) == 0 {
+		return
+	}
+
+	hists, err := fquery.WithContext(cache).HistLimitContext(ctx, symbols, start, end)
+	if err != nil {
+		vprintln("could not calculate the moving averages,", err)
+	}
+	for i := range quotes {
+		if h, ok := hists[quotes[i].Symbol]; ok && indicators.FillMovingAverages(&quotes[i], h) {
+			vprintln("calculated the moving averages of", quotes[i].Symbol, "from the history")
+		}
+	}
 }
 
 func wouldRichieRichBuy(res fquery.Quote) bool {
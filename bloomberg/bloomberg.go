@@ -1,95 +1,194 @@
 package bloomberg
 
 import (
+	"context"
 	"fmt"
 	"github.com/aktau/gofinance/fquery"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
 var VERBOSITY = 0
 
-type Source struct{}
+const (
+	BASE_URL = "http://www.bloomberg.com"
 
-func New() fquery.Source {
-	return &Source{}
+	/* bloomberg serves a stripped down page (or nothing at all) to clients
+	 * that don't look like a browser */
+	USER_AGENT = "Mozilla/5.0 (X11; Linux x86_64; rv:26.0) Gecko/20100101 Firefox/26.0"
+)
+
+type Source struct {
+	client    *http.Client
+	baseURL   string
+	userAgent string
+	proxy     *url.URL
 }
 
-func (s *Source) Quote(symbols []string) ([]fquery.Quote, error) {
-	symbols = convertSymbols(symbols)
+/* Option configures a Source, pass them to New */
+type Option func(s *Source)
 
-	slice := make([]fquery.Quote, 0, len(symbols))
+/* WithClient makes the source use client instead of http.DefaultClient */
+func WithClient(client *http.Client) Option {
+	return func(s *Source) {
+		s.client = client
+	}
+}
 
-	results := make(chan *fquery.Quote, len(symbols))
-	errors := make(chan error, len(symbols))
-
-	/* fetch all symbols in parallel */
-	for _, symbol := range symbols {
-		go func(symbol string) {
-			quote, err := getQuote(symbol)
-			if err != nil {
-				errors <- err
-			} else {
-				results <- quote
-			}
-		}(symbol)
+/* WithBaseURL points the source somewhere else than BASE_URL, the paths
+ * stay the same */
+func WithBaseURL(url string) Option {
+	return func(s *Source) {
+		s.baseURL = strings.TrimSuffix(url, "/")
 	}
+}
 
-	for i := 0; i < len(symbols); i++ {
-		select {
-		case err := <-errors:
-			fmt.Println("bloomberg: error while fetching,", err)
-		case r := <-results:
-			r.Symbol = bloombergToYahoo(r.Symbol)
-			slice = append(slice, *r)
-		}
+func WithUserAgent(ua string) Option {
+	return func(s *Source) {
+		s.userAgent = ua
 	}
+}
 
-	return slice, nil
+/* WithProxy sends all requests through proxy, whichever client is used.
+ * Clients with a Transport that isn't an *http.Transport are left alone,
+ * their RoundTripper is assumed to know where to go. */
+func WithProxy(proxy *url.URL) Option {
+	return func(s *Source) {
+		s.proxy = proxy
+	}
 }
 
-func (s *Source) Hist(symbols []string) (map[string]fquery.Hist, error) {
-	symbols = convertSymbols(symbols)
-
-	m := make(map[string]fquery.Hist, 0)
-
-	results := make(chan *fquery.Hist, len(symbols))
-	errors := make(chan error, len(symbols))
-
-	/* fetch all symbols in parallel */
-	for _, symbol := range symbols {
-		go func(symbol string) {
-			quote, err := getHist(symbol)
-			if err != nil {
-				errors <- err
-			} else {
-				results <- quote
-			}
-		}(symbol)
+func New(opts ...Option) fquery.Source {
+	s := &Source{
+		client:    http.DefaultClient,
+		baseURL:   BASE_URL,
+		userAgent: USER_AGENT,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	for i := 0; i < len(symbols); i++ {
-		select {
-		case err := <-errors:
-			fmt.Println("bloomberg: hist error,", err)
-		case r := <-results:
-			r.Symbol = bloombergToYahoo(r.Symbol)
-			m[r.Symbol] = *r
+	if s.proxy != nil {
+		/* don't touch the client we were given, it might be shared */
+		client := *s.client
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		if t, ok := transport.(*http.Transport); ok {
+			t = t.Clone()
+			t.Proxy = http.ProxyURL(s.proxy)
+			client.Transport = t
+			s.client = &client
+		} else {
+			vprintln("bloomberg: can't set a proxy on a", fmt.Sprintf("%T,", transport), "using the transport as it is")
 		}
 	}
 
-	return m, nil
+	return s
+}
+
+func (s *Source) Quote(symbols []string) ([]fquery.Quote, error) {
+	return s.QuoteContext(context.Background(), symbols)
+}
+
+func (s *Source) QuoteContext(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	slice := make([]fquery.Quote, 0, len(symbols))
+	err := s.parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		return s.getQuote(ctx, symbol)
+	}, func(symbol string, v interface{}) {
+		quote := v.(*fquery.Quote)
+		quote.Symbol = symbol
+		slice = append(slice, *quote)
+	})
+	return slice, err
+}
+
+func (s *Source) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	return s.HistContext(context.Background(), symbols)
+}
+
+/* asks bloomberg for the largest chart range it has */
+func (s *Source) HistContext(ctx context.Context, symbols []string) (map[string]fquery.Hist, error) {
+	return s.hist(ctx, symbols, func(symbol string) (*fquery.Hist, error) {
+		return s.getHist(ctx, symbol, maxRange())
+	})
 }
 
 func (s *Source) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
-	return nil, fmt.Errorf(fquery.ErrTplNotSupported, s.String(), "histlimit")
+	return s.HistLimitContext(context.Background(), symbols, start, end)
+}
+
+/* bloomberg only has a handful of fixed ranges, so the smallest one that
+ * covers start is fetched and trimmed down */
+func (s *Source) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	r := rangeFor(start)
+	return s.hist(ctx, symbols, func(symbol string) (*fquery.Hist, error) {
+		hist, err := s.getHist(ctx, symbol, r)
+		if err != nil {
+			return nil, err
+		}
+
+		trim(hist, start, end)
+		if len(hist.Entries) == 0 {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+				fmt.Errorf("no data points between %v and %v in range %v",
+					start.Format("2006-01-02"), end.Format("2006-01-02"), r.Name))
+		}
+		return hist, nil
+	})
 }
 
 func (s *Source) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
-	return nil, fmt.Errorf(fquery.ErrTplNotSupported, s.String(), "dividendhist")
+	return s.DividendHistContext(context.Background(), symbols)
+}
+
+func (s *Source) DividendHistContext(ctx context.Context, symbols []string) (map[string]fquery.DividendHist, error) {
+	return s.dividends(ctx, symbols, func(symbol string) (*fquery.DividendHist, error) {
+		return s.getDividends(ctx, symbol)
+	})
 }
 
 func (s *Source) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
-	return nil, fmt.Errorf(fquery.ErrTplNotSupported, s.String(), "dividendhistlimi")
+	return s.DividendHistLimitContext(context.Background(), symbols, start, end)
+}
+
+/* the dividend page always has the full history, it's trimmed here */
+func (s *Source) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return s.dividends(ctx, symbols, func(symbol string) (*fquery.DividendHist, error) {
+		d, err := s.getDividends(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		trimDividends(d, start, end)
+		return d, nil
+	})
+}
+
+func (s *Source) Capabilities() fquery.Capabilities {
+	return fquery.Capabilities{
+		Actions: []fquery.Action{
+			fquery.ActionQuote,
+			fquery.ActionHist,
+			fquery.ActionHistLimit,
+			fquery.ActionDividendHist,
+			fquery.ActionDividendHistLimit,
+		},
+		HistRanges: histRanges,
+		Intervals:  []fquery.Interval{fquery.Daily},
+		QuoteFields: []string{
+			"Symbol", "Name", "Updated", "Volume",
+			"Open", "PreviousClose", "LastTradePrice",
+			"DayLow", "DayHigh", "YearLow", "YearHigh",
+			"DividendYield", "DividendExDate", "EarningsPerShare",
+			"YearReturn", "PeRatio", "PeRatioEst", "PeRatioRelToIndex",
+			"EarningsPerShareEst", "DividendGrowth5y",
+			"FundType", "Nav", "ExpenseRatio", "TotalAssets",
+		},
+	}
 }
 
 func (s *Source) String() string {
@@ -98,7 +197,7 @@ func (s *Source) String() string {
 
 func vprintln(a ...interface{}) (int, error) {
 	if VERBOSITY > 0 {
-		return fmt.Println(a...)
+		return fmt.Fprintln(os.Stderr, a...)
 	}
 
 	return 0, nil
@@ -106,8 +205,83 @@ func vprintln(a ...interface{}) (int, error) {
 
 func vprintf(format string, a ...interface{}) (int, error) {
 	if VERBOSITY > 0 {
-		return fmt.Printf(format, a...)
+		return fmt.Fprintf(os.Stderr, format, a...)
 	}
 
 	return 0, nil
 }
+
+/* runs fetch for all symbols in parallel and gathers the histories */
+func (s *Source) hist(ctx context.Context, symbols []string, fetch func(symbol string) (*fquery.Hist, error)) (map[string]fquery.Hist, error) {
+	m := make(map[string]fquery.Hist, len(symbols))
+	err := s.parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		return fetch(symbol)
+	}, func(symbol string, v interface{}) {
+		hist := v.(*fquery.Hist)
+		hist.Symbol = symbol
+		m[symbol] = *hist
+	})
+	return m, err
+}
+
+/* like hist, for dividends */
+func (s *Source) dividends(ctx context.Context, symbols []string, fetch func(symbol string) (*fquery.DividendHist, error)) (map[string]fquery.DividendHist, error) {
+	m := make(map[string]fquery.DividendHist, len(symbols))
+	err := s.parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		return fetch(symbol)
+	}, func(symbol string, v interface{}) {
+		d := v.(*fquery.DividendHist)
+		d.Symbol = symbol
+		m[symbol] = *d
+	})
+	return m, err
+}
+
+/* fquery.Parallel with the symbols converted: fetch gets the bloomberg
+ * ones, collect and the errors the yahoo ones */
+func (s *Source) parallel(ctx context.Context, symbols []string,
+	fetch func(symbol string) (interface{}, error),
+	collect func(symbol string, v interface{})) error {
+
+	errs := fquery.Parallel(ctx, convertSymbols(symbols), fetch, func(symbol string, v interface{}) {
+		collect(bloombergToYahoo(symbol), v)
+	})
+
+	converted := make(fquery.SymbolErrors, len(errs))
+	for _, symbol := range errs.Symbols() {
+		vprintln("bloomberg: error while fetching,", errs[symbol])
+		converted.Add(bloombergToYahoo(symbol), errs[symbol].Kind, errs[symbol].Err)
+	}
+	return converted.Err()
+}
+
+/* bloomberg answers with a 404 for symbols it doesn't know, everything
+ * else that isn't a 200 is treated as the site being unavailable */
+func checkStatus(symbol, url string, resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+			fmt.Errorf("url: %v, status: %v", url, resp.Status))
+	case resp.StatusCode != http.StatusOK:
+		return fquery.NewSymbolError(symbol, fquery.KindNetwork,
+			fmt.Errorf("url: %v, status: %v", url, resp.Status))
+	}
+	return nil
+}
+
+/* the full url of path (relative to the base url), with args filled in */
+func (s *Source) url(path string, args ...interface{}) string {
+	return s.baseURL + fmt.Sprintf(path, args...)
+}
+
+/* like http.Get, but aborts the request when ctx is done */
+func (s *Source) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.userAgent != "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+	return s.client.Do(req.WithContext(ctx))
+}
@@ -0,0 +1,380 @@
+package bloomberg
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+	"golang.org/x/net/html"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func parse(t *testing.T, name string) *bloomQuote {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bloomQuote{}
+	walk(doc, b)
+	return b
+}
+
+func TestWalkStock(t *testing.T) {
+	b := parse(t, "quote.html")
+
+	/* header and price */
+	if b.Name != "Royal Dutch Shell PLC" {
+		t.Errorf("wrong name: %q", b.Name)
+	}
+	if !approx(b.LastTradePrice, 27.85) {
+		t.Errorf("wrong price: %v", b.LastTradePrice)
+	}
+
+	/* bloomsnapshot */
+	floats := []struct {
+		field     string
+		got, want float64
+	}{
+		{"Open", b.Open, 27.60},
+		{"PrevClose", b.PrevClose, 27.55},
+		{"DayLow", b.DayLow, 27.52},
+		{"DayHigh", b.DayHigh, 27.95},
+		{"YearLow", b.YearLow, 23.14},
+		{"YearHigh", b.YearHigh, 28.99},
+		{"YearReturn", b.YearReturn, 0.1234},
+
+		/* bloomtable */
+		{"PeRatio", b.PeRatio, 11.02},
+		{"PeRatioEst", b.PeRatioEst, 9.87},
+		{"PeRatioRelToIndex", b.PeRatioRelToIndex, 0.81},
+		{"EarningsPerShare", b.EarningsPerShare, 2.53},
+		{"EarningsPerShareEst", b.EarningsPerShareEst, 2.82},
+		{"DividendYield", b.DividendYield, 0.0498},
+		{"DividendGrowth5y", b.DividendGrowth5y, 0.0155},
+	}
+	for _, f := range floats {
+		if !approx(f.got, f.want) {
+			t.Errorf("wrong %v: got %v, want %v", f.field, f.got, f.want)
+		}
+	}
+
+	if b.Volume != 4231667 {
+		t.Errorf("wrong volume: %v", b.Volume)
+	}
+	if want := time.Date(2014, time.February, 12, 0, 0, 0, 0, time.UTC); !b.DividendExDate.Equal(want) {
+		t.Errorf("wrong ex-date: %v", b.DividendExDate)
+	}
+	if b.IsFund {
+		t.Errorf("a stock was taken for a fund")
+	}
+}
+
+func TestWalkFund(t *testing.T) {
+	b := parse(t, "fund.html")
+
+	if !b.IsFund || b.FundType != "ETF" {
+		t.Errorf("fund not recognized: %v, %q", b.IsFund, b.FundType)
+	}
+
+	floats := []struct {
+		field     string
+		got, want float64
+	}{
+		{"Nav", b.Nav, 25.62},
+		{"TotalAssets", b.TotalAssets, 1204.5e6},
+		{"ExpenseRatio", b.ExpenseRatio, 0.0012},
+		{"FundYield", b.FundYield, 0.0284},
+		{"PrevClose", b.PrevClose, 25.45},
+	}
+	for _, f := range floats {
+		if !approx(f.got, f.want) {
+			t.Errorf("wrong %v: got %v, want %v", f.field, f.got, f.want)
+		}
+	}
+}
+
+/* a stand-in for bloomberg that serves the fixtures for the known
+ * symbols and 404s for everything else */
+type server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	ranges    []string
+	userAgent string
+	host      string
+	chart     func(w http.ResponseWriter, r *http.Request)
+}
+
+func newServer() *server {
+	s := &server{}
+	s.chart = func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", "chart.json"))
+	}
+
+	fixtures := map[string]string{
+		"/quote/RDSA:NA":           "quote.html",
+		"/quote/VEUR:NA":           "fund.html",
+		"/quote/RDSA:NA/dividends": "dividends.html",
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.userAgent = r.UserAgent()
+		s.host = r.Host
+		s.mu.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/markets/chart/data/") {
+			parts := strings.Split(r.URL.Path, "/")
+			if parts[len(parts)-1] != "VEUR:NA" {
+				http.NotFound(w, r)
+				return
+			}
+			s.mu.Lock()
+			s.ranges = append(s.ranges, parts[len(parts)-2])
+			s.mu.Unlock()
+			s.chart(w, r)
+			return
+		}
+
+		name, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("testdata", name))
+	}))
+	return s
+}
+
+func TestQuote(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	src := New(WithClient(srv.Client()), WithBaseURL(srv.URL), WithUserAgent("gofinance-test"))
+	quotes, err := src.Quote([]string{"RDSA.AS", "VEUR.AS", "NOPE.AS"})
+
+	if fquery.KindOf(err) != fquery.KindUnknownSymbol {
+		t.Errorf("expected NOPE.AS to be unknown, got %v", err)
+	}
+	if serrs, ok := err.(fquery.SymbolErrors); !ok || len(serrs) != 1 || serrs["NOPE.AS"] == nil {
+		t.Errorf("expected exactly one error, for NOPE.AS, got %v", err)
+	}
+	if srv.userAgent != "gofinance-test" {
+		t.Errorf("user agent not sent, got %q", srv.userAgent)
+	}
+
+	m := fquery.QuotesToMap(quotes)
+	if len(m) != 2 {
+		t.Fatalf("expected 2 quotes, got %v", len(quotes))
+	}
+
+	q := m["RDSA.AS"]
+	if q == nil || q.Name != "Royal Dutch Shell PLC" || !approx(q.LastTradePrice, 27.85) ||
+		!approx(q.PeRatioEst, 9.87) || !approx(q.YearReturn, 0.1234) || q.IsFund() {
+		t.Errorf("wrong stock quote: %+v", q)
+	}
+
+	q = m["VEUR.AS"]
+	if q == nil || !q.IsFund() || !approx(q.LastTradePrice, 25.62) ||
+		!approx(q.DividendYield, 0.0284) || !approx(q.ExpenseRatio, 0.0012) {
+		t.Errorf("wrong fund quote: %+v", q)
+	}
+}
+
+func TestHist(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	src := New(WithClient(srv.Client()), WithBaseURL(srv.URL))
+	hists, err := src.Hist([]string{"VEUR.AS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.ranges) != 1 || srv.ranges[0] != maxRange().Name {
+		t.Errorf("expected the largest range to be requested, got %v", srv.ranges)
+	}
+
+	h := hists["VEUR.AS"]
+	if len(h.Entries) != 3 {
+		t.Fatalf("expected 3 daily entries, got %v", len(h.Entries))
+	}
+	if last := h.Entries[2]; !approx(last.Close, 25.62) {
+		t.Errorf("the last point of the day should be the close, got %v", last.Close)
+	}
+	if !h.From.Before(h.To) {
+		t.Errorf("wrong range: %v - %v", h.From, h.To)
+	}
+}
+
+func TestHistLimit(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	/* the ranges always end today, so the data has to as well */
+	now := time.Now()
+	srv.chart = func(w http.ResponseWriter, r *http.Request) {
+		var v bloomHist
+		for _, daysAgo := range []int{60, 30, 20, 1} {
+			ms := now.AddDate(0, 0, -daysAgo).Unix() * 1000
+			v.DataValues = append(v.DataValues, bloomHistValues{float64(ms), float64(daysAgo)})
+		}
+		json.NewEncoder(w).Encode(v)
+	}
+
+	src := New(WithClient(srv.Client()), WithBaseURL(srv.URL))
+	start, end := now.AddDate(0, 0, -40), now.AddDate(0, 0, -10)
+	hists, err := src.HistLimit([]string{"VEUR.AS"}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.ranges) != 1 || srv.ranges[0] != "1Y" {
+		t.Errorf("expected the 1Y range to be requested, got %v", srv.ranges)
+	}
+
+	h := hists["VEUR.AS"]
+	if len(h.Entries) != 2 || h.Entries[0].Close != 30 || h.Entries[1].Close != 20 {
+		t.Errorf("expected the entries of 30 and 20 days ago, got %+v", h.Entries)
+	}
+}
+
+func TestRangeFor(t *testing.T) {
+	cases := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{time.Hour, "1D"},
+		{10 * day, "1M"},
+		{200 * day, "1Y"},
+		{3 * 365 * day, "5Y"},
+		{20 * 365 * day, "5Y"},
+	}
+	for _, c := range cases {
+		if r := rangeFor(time.Now().Add(-c.ago)); r.Name != c.want {
+			t.Errorf("%v ago: got range %v, want %v", c.ago, r.Name, c.want)
+		}
+	}
+}
+
+/* a window that starts at midnight east of UTC mustn't gain the day
+ * before */
+func TestTrim(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	h := &fquery.Hist{Symbol: "VEUR.AS"}
+	for d := 1; d <= 3; d++ {
+		h.Entries = append(h.Entries, fquery.HistEntry{
+			Date:  util.YearMonthDay(time.Date(2014, time.January, d, 16, 0, 0, 0, loc)),
+			Close: float64(d),
+		})
+	}
+
+	day2 := time.Date(2014, time.January, 2, 0, 0, 0, 0, loc)
+	trim(h, day2, day2)
+	if len(h.Entries) != 1 || h.Entries[0].Close != 2 {
+		t.Errorf("expected only the second of january, got %+v", h.Entries)
+	}
+
+	/* ex-dates are plain dates, midnight in UTC */
+	d := &fquery.DividendHist{Symbol: "RDSA.AS"}
+	for i := 1; i <= 3; i++ {
+		d.Dividends = append(d.Dividends, fquery.DividendEntry{
+			Date:      util.YearMonthDay(time.Date(2014, time.January, i, 0, 0, 0, 0, time.UTC)),
+			Dividends: float64(i),
+		})
+	}
+	trimDividends(d, day2, day2)
+	if len(d.Dividends) != 1 || d.Dividends[0].Dividends != 2 {
+		t.Errorf("expected only the dividend of the second of january, got %+v", d.Dividends)
+	}
+}
+
+func TestDividendHist(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	src := New(WithClient(srv.Client()), WithBaseURL(srv.URL))
+	divs, err := src.DividendHist([]string{"RDSA.AS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := divs["RDSA.AS"].Dividends
+	if len(d) != 3 {
+		t.Fatalf("expected 3 dividends (one has no ex-date yet), got %+v", d)
+	}
+	first, last := d[0].Date.GetTime(), d[2].Date.GetTime()
+	if first.Month() != time.August || last.Month() != time.February || !approx(d[2].Dividends, 0.45) {
+		t.Errorf("wrong dividends: %+v", d)
+	}
+
+	start := time.Date(2013, time.October, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2014, time.February, 12, 0, 0, 0, 0, time.UTC)
+	divs, err = src.DividendHistLimit([]string{"RDSA.AS"}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(divs["RDSA.AS"].Dividends); n != 2 {
+		t.Errorf("expected 2 dividends in the window, got %v", n)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	proxy, _ := url.Parse(srv.URL)
+	src := New(WithBaseURL("http://bloomberg.invalid"), WithProxy(proxy))
+	if _, err := src.Quote([]string{"RDSA.AS"}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.host != "bloomberg.invalid" {
+		t.Errorf("request didn't go through the proxy, host: %v", srv.host)
+	}
+}
+
+type roundTripper struct {
+	rt    http.RoundTripper
+	calls int
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.calls++
+	return r.rt.RoundTrip(req)
+}
+
+func TestProxyCustomTransport(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	/* a transport that isn't an *http.Transport stays in use */
+	rt := &roundTripper{rt: srv.Client().Transport}
+	proxy, _ := url.Parse("http://proxy.invalid")
+	src := New(WithClient(&http.Client{Transport: rt}), WithBaseURL(srv.URL), WithProxy(proxy))
+	if _, err := src.Quote([]string{"RDSA.AS"}); err != nil {
+		t.Fatal(err)
+	}
+	if rt.calls == 0 {
+		t.Errorf("the transport of the client was replaced")
+	}
+}
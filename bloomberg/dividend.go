@@ -0,0 +1,173 @@
+package bloomberg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+	"golang.org/x/net/html"
+)
+
+const (
+	DIVIDEND_PATH = "/quote/%s/dividends"
+)
+
+/* bloomberg lists every dividend the company ever declared in one table,
+ * with the declaration, ex-, record and payment dates and the amount. The
+ * ex-date is what matters to shareholders, so that's the one that's kept. */
+func (s *Source) getDividends(ctx context.Context, symbol string) (d *fquery.DividendHist, err error) {
+	url := s.url(DIVIDEND_PATH, symbol)
+	vprintln("bloomberg: fetching dividends,", url)
+	resp, err := s.get(ctx, url)
+	if err != nil {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindOf(err),
+			fmt.Errorf("error while fetching, url: %v, error: %w", url, err))
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(symbol, url, resp); err != nil {
+		return nil, err
+	}
+
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindOf(err),
+			fmt.Errorf("html parse error, url: %v, error: %w", url, err))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			d, err = nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+				fmt.Errorf("unexpected page structure, url: %v, error: %v", url, r))
+		}
+	}()
+
+	table := findDividendTable(doc)
+	if table == nil {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+			fmt.Errorf("could not find the dividend table, url: %v", url))
+	}
+
+	entries, err := bloomdividends(table)
+	if err != nil {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+			fmt.Errorf("%v, url: %v", err, url))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Date.GetTime().Before(entries[j].Date.GetTime())
+	})
+	return &fquery.DividendHist{Symbol: symbol, Dividends: entries}, nil
+}
+
+/* the first table of class "dividends" */
+func findDividendTable(n *html.Node) *html.Node {
+	if isTag(n, "table") && hasClass(n, "dividends") {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if t := findDividendTable(c); t != nil {
+			return t
+		}
+	}
+
+	return nil
+}
+
+/* the columns are found by their headers, bloomberg has been known to
+ * shuffle them around */
+func bloomdividends(table *html.Node) ([]fquery.DividendEntry, error) {
+	exDateCol, amountCol := -1, -1
+	entries := make([]fquery.DividendEntry, 0, 32)
+
+	for _, tr := range allTags(table, "tr") {
+		cells := make([]string, 0, 6)
+		headers := false
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if isTag(c, "th") || isTag(c, "td") {
+				headers = headers || isTag(c, "th")
+				cells = append(cells, strings.TrimSpace(alltext(c)))
+			}
+		}
+
+		if headers {
+			for i, hdr := range cells {
+				switch {
+				case strings.Contains(hdr, "Ex") && strings.Contains(hdr, "Date"):
+					exDateCol = i
+				case strings.Contains(hdr, "Amount"):
+					amountCol = i
+				}
+			}
+			continue
+		}
+
+		if exDateCol < 0 || amountCol < 0 {
+			return nil, fmt.Errorf("dividend table lacks an ex-date or amount column")
+		}
+		if len(cells) <= exDateCol || len(cells) <= amountCol {
+			continue
+		}
+
+		t, err := time.Parse("02/01/2006", cells[exDateCol])
+		if err != nil {
+			/* announced but not yet scheduled dividends have no ex-date */
+			vprintln("bloomberg: skipping dividend without ex-date,", cells)
+			continue
+		}
+
+		/* e.g.: 0.25 USD */
+		amount := strings.Fields(cells[amountCol])
+		if len(amount) == 0 {
+			continue
+		}
+
+		entries = append(entries, fquery.DividendEntry{
+			Date:      util.YearMonthDay(t),
+			Dividends: atof(stripchars(amount[0], ",")),
+		})
+	}
+
+	return entries, nil
+}
+
+/* drops all entries outside of [start, end], whole days count */
+func trimDividends(d *fquery.DividendHist, start time.Time, end time.Time) {
+	entries := d.Dividends[:0]
+	for _, e := range d.Dividends {
+		if withinDays(e.Date.GetTime(), start, end) {
+			entries = append(entries, e)
+		}
+	}
+	d.Dividends = entries
+}
+
+/* all descendants of n with the given tag, in document order */
+func allTags(n *html.Node, tag string) []*html.Node {
+	var nodes []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isTag(c, tag) {
+			nodes = append(nodes, c)
+		}
+		nodes = append(nodes, allTags(c, tag)...)
+	}
+	return nodes
+}
+
+/* unlike text, this doesn't assume the text is the first child */
+func alltext(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(alltext(c))
+	}
+	return b.String()
+}
@@ -1,74 +1,135 @@
 package bloomberg
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aktau/gofinance/fquery"
 	"github.com/aktau/gofinance/util"
-	"net/http"
 	"time"
 )
 
 const (
-	HIST_URL = "http://www.bloomberg.com/markets/chart/data/%s/%s"
+	HIST_PATH = "/markets/chart/data/%s/%s"
 )
 
+const day = 24 * time.Hour
+
+/* the chart ranges bloomberg offers, from small to large, they always end
+ * at the current day */
+var histRanges = []fquery.HistRange{
+	{Name: "1D", Span: day},
+	{Name: "1M", Span: 31 * day},
+	{Name: "1Y", Span: 366 * day},
+	{Name: "5Y", Span: 5*366*day + day},
+}
+
+/* the largest range bloomberg has, used for plain Hist */
+func maxRange() fquery.HistRange {
+	return histRanges[len(histRanges)-1]
+}
+
+/* returns the smallest range that reaches back to start, or the largest
+ * one if none do, in which case the history will be shorter than asked */
+func rangeFor(start time.Time) fquery.HistRange {
+	since := time.Since(start)
+	for _, r := range histRanges {
+		if since <= r.Span {
+			return r
+		}
+	}
+	return maxRange()
+}
+
 type bloomHistValues [2]float64
 
 type bloomHist struct {
 	DataValues []bloomHistValues `json:"data_values"`
 }
 
-func getHist(symbol string) (*fquery.Hist, error) {
-	url := fmt.Sprintf(HIST_URL, "1Y", symbol)
+func (s *Source) getHist(ctx context.Context, symbol string, r fquery.HistRange) (*fquery.Hist, error) {
+	url := s.url(HIST_PATH, r.Name, symbol)
 	vprintln("bloomberg: fetching historical,", url)
-	resp, err := http.Get(url)
+	resp, err := s.get(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("%v, error while fetching, url: %v, error: %v", symbol, url, err)
+		return nil, fquery.NewSymbolError(symbol, fquery.KindOf(err),
+			fmt.Errorf("error while fetching, url: %v, error: %w", url, err))
 	}
 	defer resp.Body.Close()
 
-	dec := json.NewDecoder(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("%v, json new decoder error, url: %v, error: %v", symbol, url, err)
+	if err := checkStatus(symbol, url, resp); err != nil {
+		return nil, err
 	}
 
+	dec := json.NewDecoder(resp.Body)
+
 	var v bloomHist
 	if err := dec.Decode(&v); err != nil {
-		return nil, fmt.Errorf("%v, json decode error, url: %v, error: %v", symbol, url, err)
+		return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+			fmt.Errorf("json decode error, url: %v, error: %w", url, err))
 	}
 
 	if len(v.DataValues) == 0 {
-		return nil, fmt.Errorf("%v, did not return any data"+
-			"points, symbol is possibly not indexed by bloomberg, url: %v",
-			symbol, url)
+		return nil, fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+			fmt.Errorf("did not return any data points, symbol is possibly "+
+				"not indexed by bloomberg, url: %v", url))
 	}
 
-	var (
-		minDate time.Time
-		maxDate time.Time
-	)
-	entries := make([]fquery.HistEntry, 0, 365)
-	for idx, e := range v.DataValues {
+	entries := make([]fquery.HistEntry, 0, len(v.DataValues))
+	for _, e := range v.DataValues {
 		t := time.Unix(int64(e[0])/1000, 0)
-
-		if idx == 0 {
-			minDate = t
-		}
-		if idx == len(v.DataValues)-1 {
-			maxDate = t
-		}
-
-		entries = append(entries, fquery.HistEntry{
+		entry := fquery.HistEntry{
 			Date:  util.YearMonthDay(t),
 			Close: e[1],
-		})
+		}
+
+		/* the small ranges have more than one point per day, the last
+		 * one of the day is the closest we have to a close */
+		if n := len(entries); n > 0 && sameDay(entries[n-1].Date.GetTime(), t) {
+			entries[n-1] = entry
+		} else {
+			entries = append(entries, entry)
+		}
 	}
 
 	return &fquery.Hist{
 		Symbol:  symbol,
-		From:    minDate,
-		To:      maxDate,
+		From:    entries[0].Date.GetTime(),
+		To:      entries[len(entries)-1].Date.GetTime(),
 		Entries: entries,
 	}, nil
 }
+
+/* drops all entries outside of [start, end], whole days count */
+func trim(hist *fquery.Hist, start time.Time, end time.Time) {
+	entries := hist.Entries[:0]
+	for _, e := range hist.Entries {
+		if withinDays(e.Date.GetTime(), start, end) {
+			entries = append(entries, e)
+		}
+	}
+
+	hist.Entries = entries
+	if len(entries) > 0 {
+		hist.From = entries[0].Date.GetTime()
+		hist.To = entries[len(entries)-1].Date.GetTime()
+	}
+}
+
+/* whether the date of t falls in [start, end], each date as it is in
+ * its own location: truncating would count in UTC */
+func withinDays(t, start, end time.Time) bool {
+	d := dateOf(t)
+	return !d.Before(dateOf(start)) && !d.After(dateOf(end))
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
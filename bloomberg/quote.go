@@ -1,8 +1,9 @@
 package bloomberg
 
 import (
+	"context"
 	"fmt"
-	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -32,25 +33,57 @@ type bloomQuote struct {
 	DividendYield    float64
 	DividendGrowth5y float64
 	DividendExDate   time.Time
+
+	/* only filled in for funds/ETFs, which have their own layout */
+	IsFund       bool
+	FundType     string
+	Nav          float64
+	FundYield    float64
+	ExpenseRatio float64
+	TotalAssets  float64
 }
 
-func getQuote(symbol string) (*fquery.Quote, error) {
-	resp, err := http.Get("http://www.bloomberg.com/quote/" + symbol)
+const (
+	QUOTE_PATH = "/quote/%s"
+)
+
+func (s *Source) getQuote(ctx context.Context, symbol string) (q *fquery.Quote, err error) {
+	url := s.url(QUOTE_PATH, symbol)
+	resp, err := s.get(ctx, url)
 	if err != nil {
-		return nil, err
+		return nil, fquery.NewSymbolError(symbol, fquery.KindOf(err),
+			fmt.Errorf("error while fetching, url: %v, error: %w", url, err))
 	}
 	defer resp.Body.Close()
 
+	if err := checkStatus(symbol, url, resp); err != nil {
+		return nil, err
+	}
+
 	doc, err := html.Parse(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, fquery.NewSymbolError(symbol, fquery.KindOf(err),
+			fmt.Errorf("html parse error, url: %v, error: %w", url, err))
 	}
 
-	/* TODO: detect if fund or plain stock, different layouts... */
+	/* the walker assumes the page looks like it always did, if it doesn't
+	 * it's liable to stumble over a nil node */
+	defer func() {
+		if r := recover(); r != nil {
+			q, err = nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+				fmt.Errorf("unexpected page structure, url: %v, error: %v", url, r))
+		}
+	}()
+
 	quote := &bloomQuote{}
 	walk(doc, quote)
 
-	return &fquery.Quote{
+	if quote.Name == "" && quote.LastTradePrice == 0 && quote.Nav == 0 {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+			fmt.Errorf("could not find a name or price on the page, url: %v", url))
+	}
+
+	q = &fquery.Quote{
 		Name:             quote.Name,
 		Symbol:           symbol,
 		Updated:          time.Now(),
@@ -62,10 +95,34 @@ func getQuote(symbol string) (*fquery.Quote, error) {
 		YearLow:          quote.YearLow,
 		YearHigh:         quote.YearHigh,
 		LastTradePrice:   quote.LastTradePrice,
+		YearReturn:       quote.YearReturn,
 		DividendYield:    quote.DividendYield,
-		EarningsPerShare: quote.EarningsPerShare,
 		DividendExDate:   quote.DividendExDate,
-	}, nil
+		DividendGrowth5y: quote.DividendGrowth5y,
+		PeRatio:          quote.PeRatio,
+		EarningsPerShare: quote.EarningsPerShare,
+
+		PeRatioEst:          quote.PeRatioEst,
+		PeRatioRelToIndex:   quote.PeRatioRelToIndex,
+		EarningsPerShareEst: quote.EarningsPerShareEst,
+	}
+
+	if quote.IsFund {
+		q.FundType = quote.FundType
+		q.Nav = quote.Nav
+		q.ExpenseRatio = quote.ExpenseRatio
+		q.TotalAssets = quote.TotalAssets
+		if quote.FundYield != 0 {
+			q.DividendYield = quote.FundYield
+		}
+
+		/* funds that aren't traded on an exchange only have a NAV */
+		if q.LastTradePrice == 0 {
+			q.LastTradePrice = quote.Nav
+		}
+	}
+
+	return q, nil
 }
 
 /* could be made mode efficient if we parse layer by layer, and specify for
@@ -81,6 +138,11 @@ func walk(n *html.Node, b *bloomQuote) bool {
 			/* select the table that follows */
 			bloomtable(findFirstChild(n, "table"), b)
 			return false
+		case n.Data == "div" && hasClass(n, "fund_profile"):
+			/* funds/ETFs have a profile instead of key statistics */
+			b.IsFund = true
+			bloomfund(findFirstChild(n, "table"), b)
+			return false
 		case n.Data == "span" && hasClass(n, "price"):
 			b.LastTradePrice = atof(strings.TrimSpace(n.FirstChild.Data))
 		case n.Data == "table" && hasClass(n, "snapshot_table"):
@@ -123,12 +185,12 @@ func bloomsnapshot(n *html.Node, b *bloomQuote) {
 				case strstr(hdr, "Previous") && strstr(hdr, "Close"):
 					b.PrevClose = atof(val)
 				case strstr(hdr, "1-Yr") && strstr(hdr, "Rtn"):
-					b.YearReturn = atof(val[1:len(val)-2]) / 100
+					b.YearReturn = perc(val)
 				case strstr(hdr, "Volume"):
 					val := stripchars(val, ",")
 					vol, err := strconv.Atoi(val)
 					if err != nil {
-						fmt.Println("bloomberg: could not read volume", hdr, val)
+						fmt.Fprintln(os.Stderr, "bloomberg: could not read volume", hdr, val)
 					} else {
 						b.Volume = int64(vol)
 					}
@@ -176,23 +238,76 @@ func bloomtable(n *html.Node, b *bloomQuote) {
 			case strstr(hdr, "Est.") && strstr(hdr, "EPS"):
 				b.EarningsPerShareEst = atof(val)
 			case strstr(hdr, "Dividend") && strstr(hdr, "Yield"):
-				b.DividendYield = atof(val[0:len(val)-2]) / 100
+				b.DividendYield = perc(val)
 			case strstr(hdr, "Dividend") && strstr(hdr, "Ex-Date"):
 				t, err := time.Parse("02/01/2006", val)
 				if err != nil {
-					fmt.Println("bloomberg: can't parse time, ", val, err)
+					fmt.Fprintln(os.Stderr, "bloomberg: can't parse time, ", val, err)
 				} else {
 					b.DividendExDate = t
 				}
 			case strstr(hdr, "Dividend") && strstr(hdr, "Growth") && strstr(hdr, "5"):
-				b.DividendGrowth5y = atof(val[0:len(val)-2]) / 100
+				b.DividendGrowth5y = perc(val)
 			}
 		}
 	}
 }
 
+/* the fund profile is a table with one header/value pair per row */
+func bloomfund(n *html.Node, b *bloomQuote) {
+	if n == nil {
+		return
+	}
+
+	n = findFirstChild(n, "tbody")
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !isTag(c, "tr") {
+			continue
+		}
+
+		th := findFirstChild(c, "th")
+		td := findFirstSibling(th, "td")
+		if th == nil || td == nil || th.FirstChild == nil || td.FirstChild == nil {
+			continue
+		}
+
+		hdr := text(th)
+		val := strings.TrimSpace(text(td))
+
+		strstr := strings.Contains
+		switch {
+		case strstr(hdr, "Fund") && strstr(hdr, "Type"):
+			b.FundType = val
+		case strstr(hdr, "NAV"):
+			/* e.g.: 25.62 EUR */
+			if fields := strings.Fields(val); len(fields) > 0 {
+				b.Nav = atof(stripchars(fields[0], ","))
+			}
+		case strstr(hdr, "Total") && strstr(hdr, "Assets"):
+			/* e.g.: Total Assets (Mil): 1,204.5 */
+			assets := atof(stripchars(val, ","))
+			switch {
+			case strstr(hdr, "Mil"):
+				assets *= 1e6
+			case strstr(hdr, "Bil"):
+				assets *= 1e9
+			}
+			b.TotalAssets = assets
+		case strstr(hdr, "Expense") && strstr(hdr, "Ratio"):
+			b.ExpenseRatio = perc(val)
+		case strstr(hdr, "Yield"):
+			b.FundYield = perc(val)
+		}
+	}
+}
+
+/* e.g.: "+12.34% " -> 0.1234 */
+func perc(s string) float64 {
+	return atof(strings.TrimSuffix(strings.TrimSpace(s), "%")) / 100
+}
+
 func atof(s string) float64 {
-	f, _ := strconv.ParseFloat(s, 64)
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
 	return f
 }
 
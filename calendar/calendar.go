@@ -0,0 +1,218 @@
+package calendar
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	/* the exchanges need their time zones, don't depend on the system
+	 * having them */
+	_ "time/tzdata"
+)
+
+/* Exchange knows when a market is open: its trading sessions, time zone
+ * and holidays. Holidays are calculated from rules, holidays that can't be
+ * (lunar ones, one-off closures) can be added with AddHoliday. Early
+ * closes are not known, the session is assumed to last the whole day.
+ *
+ * Days are passed and returned as the midnight in UTC of the date as it is
+ * at the exchange, i.e.: what time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+ * gives. */
+type Exchange struct {
+	Name     string
+	Location *time.Location
+
+	/* the session of a trading day, in minutes since midnight. The open can
+	 * be negative for markets that start trading the evening before. */
+	Open, Close int
+
+	holidays func(year int) []date
+
+	mu    sync.RWMutex
+	extra map[date]bool
+}
+
+/* the amount of days any search for a session gives up after, no market
+ * is closed for that long */
+const MAX_CLOSED_DAYS = 30
+
+func newExchange(name, zone string, open, close string, holidays func(year int) []date) *Exchange {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		panic("calendar: unknown time zone " + zone)
+	}
+	return &Exchange{
+		Name:     name,
+		Location: loc,
+		Open:     minutes(open),
+		Close:    minutes(close),
+		holidays: holidays,
+		extra:    make(map[date]bool),
+	}
+}
+
+/* "09:30" -> 570, a leading minus counts from the day before */
+func minutes(clock string) int {
+	sign := 1
+	if strings.HasPrefix(clock, "-") {
+		sign, clock = -1, clock[1:]
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic("calendar: bad time of day " + clock)
+	}
+	m := t.Hour()*60 + t.Minute()
+	if sign < 0 {
+		return m - 24*60
+	}
+	return m
+}
+
+func (e *Exchange) String() string {
+	return e.Name
+}
+
+/* marks day as a day without trading */
+func (e *Exchange) AddHoliday(day time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.extra[dateOf(day)] = true
+}
+
+func (e *Exchange) isHoliday(d date) bool {
+	e.mu.RLock()
+	extra := e.extra[d]
+	e.mu.RUnlock()
+	if extra {
+		return true
+	}
+
+	for _, h := range e.holidays(d.year) {
+		if h == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Exchange) isTradingDay(d date) bool {
+	switch d.weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return !e.isHoliday(d)
+}
+
+/* whether there is a session on day */
+func (e *Exchange) IsTradingDay(day time.Time) bool {
+	return e.isTradingDay(dateOf(day))
+}
+
+func (e *Exchange) session(d date) (open, close time.Time) {
+	open = time.Date(d.year, d.month, d.day, 0, e.Open, 0, 0, e.Location)
+	close = time.Date(d.year, d.month, d.day, 0, e.Close, 0, 0, e.Location)
+	return open, close
+}
+
+/* whether the market is trading at t */
+func (e *Exchange) IsOpen(t time.Time) bool {
+	d := dateOf(t.In(e.Location))
+	for _, d := range []date{d, d.add(1)} {
+		if !e.isTradingDay(d) {
+			continue
+		}
+		if open, close := e.session(d); !t.Before(open) && t.Before(close) {
+			return true
+		}
+	}
+	return false
+}
+
+/* the end of the last session that closed at or before t */
+func (e *Exchange) LastClose(t time.Time) time.Time {
+	d := dateOf(t.In(e.Location)).add(1)
+	for i := 0; i < MAX_CLOSED_DAYS; i, d = i+1, d.add(-1) {
+		if !e.isTradingDay(d) {
+			continue
+		}
+		if _, close := e.session(d); !close.After(t) {
+			return close
+		}
+	}
+	return time.Time{}
+}
+
+/* the start of the first session that opens after t, or of the current
+ * one if the market is open */
+func (e *Exchange) NextOpen(t time.Time) time.Time {
+	d := e.firstUnclosed(t)
+	open, _ := e.session(d)
+	return open
+}
+
+/* the day of the first session that hasn't closed yet at t */
+func (e *Exchange) firstUnclosed(t time.Time) date {
+	d := dateOf(t.In(e.Location)).add(-1)
+	for i := 0; i < MAX_CLOSED_DAYS; i, d = i+1, d.add(1) {
+		if !e.isTradingDay(d) {
+			continue
+		}
+		if _, close := e.session(d); close.After(t) {
+			return d
+		}
+	}
+	return d
+}
+
+/* whether there was trading at some point between from and to, i.e.:
+ * whether prices can have changed */
+func (e *Exchange) TradedBetween(from, to time.Time) bool {
+	return e.IsOpen(to) || e.LastClose(to).After(from)
+}
+
+/* the last day whose history is final at t: every session up to and
+ * including that day has closed, and nothing will happen anymore until
+ * the next session. On a Saturday that's the Sunday, for example. */
+func (e *Exchange) LastFinalDay(t time.Time) time.Time {
+	return e.firstUnclosed(t).add(-1).time()
+}
+
+/* ForSymbol returns the exchange a Yahoo style symbol (VEUR.AS, AAPL,
+ * EURUSD=X) is traded on, nil if it's not known */
+func ForSymbol(symbol string) *Exchange {
+	if strings.HasSuffix(symbol, "=X") {
+		return Currencies
+	}
+
+	i := strings.LastIndex(symbol, ".")
+	if i < 0 {
+		return US
+	}
+	return bySuffix[strings.ToUpper(symbol[i+1:])]
+}
+
+/* sources take a while to publish the history of a day after the close */
+const SETTLE_TIME = time.Hour
+
+/* Expired says whether a quote of symbol that was fetched at fetched is
+ * out of date at now: it's older than expiry and there has been trading
+ * since. For symbols of unknown exchanges only the expiry counts. */
+func Expired(symbol string, fetched time.Time, expiry time.Duration, now time.Time) bool {
+	if !fetched.Before(now.Add(-expiry)) {
+		return false
+	}
+	if e := ForSymbol(symbol); e != nil {
+		return e.TradedBetween(fetched, now)
+	}
+	return true
+}
+
+/* FinalDay is the last day the history of symbol can't change anymore
+ * at now, see LastFinalDay. For symbols of unknown exchanges that's
+ * yesterday. */
+func FinalDay(symbol string, now time.Time) time.Time {
+	if e := ForSymbol(symbol); e != nil {
+		return e.LastFinalDay(now.Add(-SETTLE_TIME))
+	}
+	return dateOf(now).add(-1).time()
+}
@@ -0,0 +1,165 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func at(e *Exchange, y int, m time.Month, d, hour, min int) time.Time {
+	return time.Date(y, m, d, hour, min, 0, 0, e.Location)
+}
+
+func TestEaster(t *testing.T) {
+	cases := map[int]date{
+		2014: {2014, time.April, 20},
+		2019: {2019, time.April, 21},
+		2024: {2024, time.March, 31},
+		2025: {2025, time.April, 20},
+		2038: {2038, time.April, 25},
+	}
+	for year, want := range cases {
+		if got := easter(year); got != want {
+			t.Errorf("easter %v: got %v, want %v", year, got, want)
+		}
+	}
+}
+
+func TestUSHolidays(t *testing.T) {
+	/* as published by the NYSE */
+	closed := []time.Time{
+		day(2024, time.January, 1),
+		day(2024, time.January, 15),
+		day(2024, time.February, 19),
+		day(2024, time.March, 29),
+		day(2024, time.May, 27),
+		day(2024, time.June, 19),
+		day(2024, time.July, 4),
+		day(2024, time.September, 2),
+		day(2024, time.November, 28),
+		day(2024, time.December, 25),
+		day(2021, time.December, 24), /* christmas on a saturday */
+		day(2023, time.January, 2),   /* new year on a sunday */
+	}
+	for _, d := range closed {
+		if US.IsTradingDay(d) {
+			t.Errorf("%v should be a holiday", d.Format("2006-01-02"))
+		}
+	}
+
+	/* new year 2022 was on a saturday, that's not made up for */
+	for _, d := range []time.Time{day(2021, time.December, 31), day(2024, time.July, 5)} {
+		if !US.IsTradingDay(d) {
+			t.Errorf("%v should be a trading day", d.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestUKHolidays(t *testing.T) {
+	closed := []time.Time{
+		day(2021, time.December, 27),
+		day(2021, time.December, 28),
+		day(2022, time.December, 26),
+		day(2022, time.December, 27),
+		day(2024, time.May, 6),
+		day(2024, time.May, 27),
+		day(2024, time.August, 26),
+		day(2024, time.April, 1),
+	}
+	for _, d := range closed {
+		if LSE.IsTradingDay(d) {
+			t.Errorf("%v should be a holiday", d.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	e := Euronext
+
+	/* a regular friday */
+	if !e.IsOpen(at(e, 2024, time.March, 22, 12, 0)) || e.IsOpen(at(e, 2024, time.March, 22, 18, 0)) {
+		t.Errorf("wrong session on a friday")
+	}
+
+	/* good friday, then the weekend and easter monday */
+	sat := at(e, 2024, time.March, 30, 12, 0)
+	if e.IsOpen(sat) {
+		t.Errorf("open on a saturday")
+	}
+	if got, want := e.LastClose(sat), at(e, 2024, time.March, 28, 17, 30); !got.Equal(want) {
+		t.Errorf("last close: got %v, want %v", got, want)
+	}
+	if got, want := e.NextOpen(sat), at(e, 2024, time.April, 2, 9, 0); !got.Equal(want) {
+		t.Errorf("next open: got %v, want %v", got, want)
+	}
+	if got, want := e.LastFinalDay(sat), day(2024, time.April, 1); !got.Equal(want) {
+		t.Errorf("last final day: got %v, want %v", got, want)
+	}
+
+	/* nothing can have changed over the long weekend */
+	thursdayEvening := at(e, 2024, time.March, 28, 18, 0)
+	if e.TradedBetween(thursdayEvening, at(e, 2024, time.April, 2, 8, 0)) {
+		t.Errorf("traded over easter")
+	}
+	if !e.TradedBetween(thursdayEvening, at(e, 2024, time.April, 2, 9, 1)) {
+		t.Errorf("no trading after the open")
+	}
+
+	/* during the session, the day before is final */
+	if got, want := e.LastFinalDay(at(e, 2024, time.April, 2, 10, 0)), day(2024, time.April, 1); !got.Equal(want) {
+		t.Errorf("last final day during a session: got %v, want %v", got, want)
+	}
+	if got, want := e.LastFinalDay(at(e, 2024, time.April, 2, 18, 0)), day(2024, time.April, 2); !got.Equal(want) {
+		t.Errorf("last final day after a session: got %v, want %v", got, want)
+	}
+}
+
+func TestCurrencies(t *testing.T) {
+	e := Currencies
+	cases := []struct {
+		t    time.Time
+		open bool
+	}{
+		{at(e, 2024, time.March, 22, 16, 0), true},  /* friday afternoon */
+		{at(e, 2024, time.March, 22, 18, 0), false}, /* friday evening */
+		{at(e, 2024, time.March, 24, 16, 0), false}, /* sunday afternoon */
+		{at(e, 2024, time.March, 24, 18, 0), true},  /* sunday evening */
+		{at(e, 2024, time.March, 26, 3, 0), true},   /* tuesday night */
+	}
+	for _, c := range cases {
+		if e.IsOpen(c.t) != c.open {
+			t.Errorf("%v: expected open to be %v", c.t, c.open)
+		}
+	}
+}
+
+func TestAddHoliday(t *testing.T) {
+	e := newExchange("test", "Asia/Singapore", "09:00", "17:00", singaporeHolidays)
+	cny := day(2024, time.February, 12)
+	if !e.IsTradingDay(cny) {
+		t.Fatalf("lunar holidays shouldn't be known")
+	}
+	e.AddHoliday(cny)
+	if e.IsTradingDay(cny) {
+		t.Errorf("added holiday is still a trading day")
+	}
+}
+
+func TestForSymbol(t *testing.T) {
+	cases := map[string]*Exchange{
+		"VEUR.AS":  Euronext,
+		"BELG.BR":  Euronext,
+		"AAPL":     US,
+		"APC.F":    Frankfurt,
+		"EURUSD=X": Currencies,
+		"FOO.XX":   nil,
+	}
+	for symbol, want := range cases {
+		if got := ForSymbol(symbol); got != want {
+			t.Errorf("%v: got %v, want %v", symbol, got, want)
+		}
+	}
+}
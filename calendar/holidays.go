@@ -0,0 +1,246 @@
+package calendar
+
+import (
+	"time"
+)
+
+var (
+	US = newExchange("NYSE/Nasdaq", "America/New_York", "09:30", "16:00", usHolidays)
+
+	/* Amsterdam, Brussels, Paris and Lisbon share their hours (Lisbon is an
+	 * hour behind, on the clock) */
+	Euronext  = newExchange("Euronext", "Europe/Paris", "09:00", "17:30", euronextHolidays)
+	LSE       = newExchange("London Stock Exchange", "Europe/London", "08:00", "16:30", ukHolidays)
+	Xetra     = newExchange("Xetra", "Europe/Berlin", "09:00", "17:30", germanHolidays)
+	Frankfurt = newExchange("Börse Frankfurt", "Europe/Berlin", "08:00", "22:00", germanHolidays)
+	Milan     = newExchange("Borsa Italiana", "Europe/Rome", "09:00", "17:30", italianHolidays)
+	Madrid    = newExchange("Bolsa de Madrid", "Europe/Madrid", "09:00", "17:30", euronextHolidays)
+	SaoPaulo  = newExchange("B3", "America/Sao_Paulo", "10:00", "17:00", brazilianHolidays)
+	Mexico    = newExchange("Bolsa Mexicana de Valores", "America/Mexico_City", "08:30", "15:00", mexicanHolidays)
+
+	/* the lunar holidays (Chinese new year, Hari Raya, Deepavali, ...) have
+	 * to be added by hand */
+	Singapore = newExchange("Singapore Exchange", "Asia/Singapore", "09:00", "17:00", singaporeHolidays)
+
+	/* the currency markets trade around the clock, from Sunday evening
+	 * until Friday evening in New York */
+	Currencies = newExchange("Currencies", "America/New_York", "-17:00", "17:00", noHolidays)
+)
+
+/* by the suffix Yahoo gives the symbols */
+var bySuffix = map[string]*Exchange{
+	"AS": Euronext,
+	"BR": Euronext,
+	"PA": Euronext,
+	"LS": Euronext,
+	"L":  LSE,
+	"DE": Xetra,
+	"F":  Frankfurt,
+	"MI": Milan,
+	"MC": Madrid,
+	"SA": SaoPaulo,
+	"MX": Mexico,
+	"SI": Singapore,
+}
+
+func noHolidays(year int) []date {
+	return nil
+}
+
+func euronextHolidays(year int) []date {
+	e := easter(year)
+	return []date{
+		{year, time.January, 1},
+		e.add(-2), /* good friday */
+		e.add(1),  /* easter monday */
+		{year, time.May, 1},
+		{year, time.December, 25},
+		{year, time.December, 26},
+	}
+}
+
+func germanHolidays(year int) []date {
+	return append(euronextHolidays(year),
+		date{year, time.December, 24},
+		date{year, time.December, 31})
+}
+
+func italianHolidays(year int) []date {
+	return append(germanHolidays(year),
+		date{year, time.August, 15})
+}
+
+func ukHolidays(year int) []date {
+	e := easter(year)
+	hs := []date{
+		substitute(date{year, time.January, 1}),
+		e.add(-2),
+		e.add(1),
+		nthWeekday(year, time.May, time.Monday, 1),
+		nthWeekday(year, time.May, time.Monday, -1),
+		nthWeekday(year, time.August, time.Monday, -1),
+	}
+
+	/* when christmas or boxing day fall in the weekend, both move to the
+	 * first free weekday after */
+	christmas := substitute(date{year, time.December, 25})
+	boxing := substitute(date{year, time.December, 26})
+	if boxing == christmas {
+		boxing = substitute(christmas.add(1))
+	}
+	return append(hs, christmas, boxing)
+}
+
+func usHolidays(year int) []date {
+	hs := []date{
+		nthWeekday(year, time.January, time.Monday, 3),  /* martin luther king */
+		nthWeekday(year, time.February, time.Monday, 3), /* washington */
+		easter(year).add(-2),
+		nthWeekday(year, time.May, time.Monday, -1),       /* memorial day */
+		observed(date{year, time.July, 4}),                /* independence day */
+		nthWeekday(year, time.September, time.Monday, 1),  /* labor day */
+		nthWeekday(year, time.November, time.Thursday, 4), /* thanksgiving */
+		observed(date{year, time.December, 25}),
+	}
+
+	/* new year is not made up for when it falls on a saturday */
+	if ny := (date{year, time.January, 1}); ny.weekday() == time.Sunday {
+		hs = append(hs, ny.add(1))
+	} else {
+		hs = append(hs, ny)
+	}
+
+	if year >= 2022 {
+		hs = append(hs, observed(date{year, time.June, 19})) /* juneteenth */
+	}
+	return hs
+}
+
+func brazilianHolidays(year int) []date {
+	e := easter(year)
+	hs := []date{
+		{year, time.January, 1},
+		e.add(-48), /* carnival */
+		e.add(-47),
+		e.add(-2),
+		{year, time.April, 21}, /* tiradentes */
+		{year, time.May, 1},
+		e.add(60), /* corpus christi */
+		{year, time.September, 7},
+		{year, time.October, 12},
+		{year, time.November, 2},
+		{year, time.November, 15},
+		{year, time.December, 24},
+		{year, time.December, 25},
+		{year, time.December, 31},
+	}
+	if year >= 2024 {
+		hs = append(hs, date{year, time.November, 20}) /* black consciousness */
+	}
+	return hs
+}
+
+func mexicanHolidays(year int) []date {
+	e := easter(year)
+	return []date{
+		{year, time.January, 1},
+		nthWeekday(year, time.February, time.Monday, 1), /* constitution */
+		nthWeekday(year, time.March, time.Monday, 3),    /* juarez */
+		e.add(-3),
+		e.add(-2),
+		{year, time.May, 1},
+		{year, time.September, 16},
+		{year, time.November, 2},
+		nthWeekday(year, time.November, time.Monday, 3), /* revolution */
+		{year, time.December, 12},
+		{year, time.December, 25},
+	}
+}
+
+func singaporeHolidays(year int) []date {
+	/* a holiday on sunday is made up for on monday */
+	sunday := func(d date) date {
+		if d.weekday() == time.Sunday {
+			return d.add(1)
+		}
+		return d
+	}
+	return []date{
+		sunday(date{year, time.January, 1}),
+		easter(year).add(-2),
+		sunday(date{year, time.May, 1}),
+		sunday(date{year, time.August, 9}),
+		sunday(date{year, time.December, 25}),
+	}
+}
+
+/* a date without a time or location */
+type date struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+/* the date of t, in t's location */
+func dateOf(t time.Time) date {
+	y, m, d := t.Date()
+	return date{y, m, d}
+}
+
+func (d date) time() time.Time {
+	return time.Date(d.year, d.month, d.day, 0, 0, 0, 0, time.UTC)
+}
+
+func (d date) add(days int) date {
+	return dateOf(d.time().AddDate(0, 0, days))
+}
+
+func (d date) weekday() time.Weekday {
+	return d.time().Weekday()
+}
+
+/* the n-th weekday of a month, the last one if n is -1 */
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) date {
+	if n < 0 {
+		last := date{year, month + 1, 0}.add(0)
+		return last.add(-((int(last.weekday()) - int(weekday) + 7) % 7))
+	}
+
+	first := date{year, month, 1}
+	return first.add((int(weekday)-int(first.weekday())+7)%7 + 7*(n-1))
+}
+
+/* the american way: saturday moves to friday, sunday to monday */
+func observed(d date) date {
+	switch d.weekday() {
+	case time.Saturday:
+		return d.add(-1)
+	case time.Sunday:
+		return d.add(1)
+	}
+	return d
+}
+
+/* the british way: the weekend moves to monday */
+func substitute(d date) date {
+	for d.weekday() == time.Saturday || d.weekday() == time.Sunday {
+		d = d.add(1)
+	}
+	return d
+}
+
+/* easter sunday in the gregorian calendar (anonymous algorithm) */
+func easter(year int) date {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date{year, time.Month(month), day}
+}
@@ -14,3 +14,39 @@ type Cache interface {
 	HasQuote(symbol string) bool
 	HasHist(symbol string, start *time.Time, end *time.Time) bool
 }
+
+/* Inspectable caches can list what they hold, e.g.: to find out what
+ * should be prefetched before going offline */
+type Inspectable interface {
+	Contents() ([]CachedSymbol, error)
+}
+
+/* CachedSymbol describes what a cache holds on one symbol */
+type CachedSymbol struct {
+	Symbol string
+
+	QuoteUpdated time.Time /* zero if there's no quote */
+
+	Hist        []DateRange /* the days the history is known for, in order */
+	HistEntries int
+
+	Dividends        int
+	DividendsUpdated time.Time /* zero if they were never fetched */
+}
+
+/* DateRange is a range of whole days, both ends included */
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (s *CachedSymbol) HasQuote() bool {
+	return !s.QuoteUpdated.IsZero()
+}
+
+func (s *CachedSymbol) QuoteAge() time.Duration {
+	if !s.HasQuote() {
+		return 0
+	}
+	return time.Since(s.QuoteUpdated)
+}
@@ -0,0 +1,137 @@
+package fquery
+
+import (
+	"fmt"
+	"time"
+)
+
+/* Action names one of the methods of a Source */
+type Action string
+
+const (
+	ActionQuote             Action = "quote"
+	ActionHist              Action = "hist"
+	ActionHistLimit         Action = "histlimit"
+	ActionDividendHist      Action = "dividendhist"
+	ActionDividendHistLimit Action = "dividendhistlimit"
+	ActionFund              Action = "fund" /* see FundSource */
+)
+
+/* Interval is the spacing between the entries of a history */
+type Interval string
+
+const (
+	Daily   Interval = "daily"
+	Weekly  Interval = "weekly"
+	Monthly Interval = "monthly"
+)
+
+/* HistRange is a window of history a source is able to return in one
+ * go, e.g.: Bloomberg calls the last year "1Y". */
+type HistRange struct {
+	Name string
+	Span time.Duration /* 0 means: all history the source has */
+}
+
+/* Capabilities describes what a source is able to deliver, so that
+ * callers can avoid asking for things that will always fail. */
+type Capabilities struct {
+	Actions     []Action
+	HistRanges  []HistRange
+	Intervals   []Interval
+	QuoteFields []string /* names of the Quote fields the source fills in */
+}
+
+func (c Capabilities) Supports(action Action) bool {
+	for _, a := range c.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) Fills(field string) bool {
+	for _, f := range c.QuoteFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+/* MaxSpan returns the largest window of history the source offers, 0 if
+ * there's no limit to it or if it has no history at all, use
+ * Supports(ActionHist) to distinguish between those. */
+func (c Capabilities) MaxSpan() time.Duration {
+	var max time.Duration
+	for _, r := range c.HistRanges {
+		if r.Span == 0 {
+			return 0
+		}
+		if r.Span > max {
+			max = r.Span
+		}
+	}
+	return max
+}
+
+/* Union returns the capabilities of c and o combined, in order of first
+ * appearance. */
+func (c Capabilities) Union(o Capabilities) Capabilities {
+	u := Capabilities{}
+	for _, a := range append(append([]Action{}, c.Actions...), o.Actions...) {
+		if !u.Supports(a) {
+			u.Actions = append(u.Actions, a)
+		}
+	}
+	for _, r := range append(append([]HistRange{}, c.HistRanges...), o.HistRanges...) {
+		if !hasRange(u.HistRanges, r) {
+			u.HistRanges = append(u.HistRanges, r)
+		}
+	}
+	for _, i := range append(append([]Interval{}, c.Intervals...), o.Intervals...) {
+		if !hasInterval(u.Intervals, i) {
+			u.Intervals = append(u.Intervals, i)
+		}
+	}
+	for _, f := range append(append([]string{}, c.QuoteFields...), o.QuoteFields...) {
+		if !u.Fills(f) {
+			u.QuoteFields = append(u.QuoteFields, f)
+		}
+	}
+	return u
+}
+
+func hasRange(rs []HistRange, r HistRange) bool {
+	for _, x := range rs {
+		if x == r {
+			return true
+		}
+	}
+	return false
+}
+
+func hasInterval(is []Interval, i Interval) bool {
+	for _, x := range is {
+		if x == i {
+			return true
+		}
+	}
+	return false
+}
+
+/* NotSupportedError is returned by sources that are asked to perform an
+ * action they don't have in their Capabilities. */
+type NotSupportedError struct {
+	Source string
+	Action Action
+}
+
+func ErrNotSupported(src fmt.Stringer, action Action) error {
+	return &NotSupportedError{src.String(), action}
+}
+
+func (e *NotSupportedError) Error() string {
+	return fmt.Sprintf(ErrTplNotSupported, e.Source, e.Action)
+}
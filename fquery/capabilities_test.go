@@ -0,0 +1,69 @@
+package fquery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSupports(t *testing.T) {
+	c := Capabilities{
+		Actions:     []Action{ActionQuote, ActionHist},
+		QuoteFields: []string{"Bid", "Ask"},
+	}
+	if !c.Supports(ActionHist) || c.Supports(ActionFund) {
+		t.Errorf("wrong actions")
+	}
+	if !c.Fills("Ask") || c.Fills("Ma200") {
+		t.Errorf("wrong fields")
+	}
+	if (Capabilities{}).Supports(ActionQuote) {
+		t.Errorf("no capabilities support something")
+	}
+}
+
+func TestMaxSpan(t *testing.T) {
+	year := 365 * 24 * time.Hour
+	cases := []struct {
+		ranges []HistRange
+		want   time.Duration
+	}{
+		{nil, 0},
+		{[]HistRange{{"1Y", year}, {"5Y", 5 * year}, {"1M", year / 12}}, 5 * year},
+		{[]HistRange{{"1Y", year}, {"all", 0}}, 0},
+	}
+	for _, c := range cases {
+		if got := (Capabilities{HistRanges: c.ranges}).MaxSpan(); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.ranges, got, c.want)
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	a := Capabilities{
+		Actions:     []Action{ActionQuote},
+		HistRanges:  []HistRange{{"1Y", time.Hour}},
+		Intervals:   []Interval{Daily},
+		QuoteFields: []string{"Bid"},
+	}
+	b := Capabilities{
+		Actions:     []Action{ActionHist, ActionQuote},
+		HistRanges:  []HistRange{{"1Y", time.Hour}, {"5Y", 2 * time.Hour}},
+		Intervals:   []Interval{Weekly, Daily},
+		QuoteFields: []string{"Ask", "Bid"},
+	}
+	want := Capabilities{
+		Actions:     []Action{ActionQuote, ActionHist},
+		HistRanges:  []HistRange{{"1Y", time.Hour}, {"5Y", 2 * time.Hour}},
+		Intervals:   []Interval{Daily, Weekly},
+		QuoteFields: []string{"Bid", "Ask"},
+	}
+	if got := a.Union(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	/* neither is changed */
+	if len(a.Actions) != 1 || len(b.Actions) != 2 {
+		t.Errorf("union changed its arguments")
+	}
+}
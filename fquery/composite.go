@@ -0,0 +1,356 @@
+package fquery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Composite is a Source made out of several underlying sources, which
+ * are all queried in parallel. Quotes are merged field by field: for
+ * every field the value of the first source (in order of precedence) that
+ * filled it in wins. By default the precedence is the order in which the
+ * sources were passed to NewComposite, but it can be overridden per
+ * field, e.g.: dividend information from Bloomberg, volume from Yahoo.
+ *
+ * Quotes that are deemed too incomplete are only used as a last resort,
+ * when none of the sources came up with a decent quote for a symbol.
+ *
+ * Histories can't be merged in a meaningful way, for those the first
+ * source (in order) that returns something for a symbol wins.
+ *
+ * Sources are only asked to do what their Capabilities say they can,
+ * those are assumed not to change. */
+type Composite struct {
+	sources []ContextSource
+
+	/* of every source, and their union */
+	caps []Capabilities
+	all  Capabilities
+
+	/* field name -> indices into sources, highest precedence first */
+	precedence map[string][]int
+	incomplete func(q *Quote) bool
+}
+
+/* the default test for incomplete quotes, a quote without any price
+ * information is not worth much */
+func IsIncomplete(q *Quote) bool {
+	return q.LastTradePrice == 0 && q.PreviousClose == 0
+}
+
+func NewComposite(sources ...Source) *Composite {
+	c := &Composite{
+		sources:    make([]ContextSource, 0, len(sources)),
+		precedence: make(map[string][]int),
+		incomplete: IsIncomplete,
+	}
+	for _, src := range sources {
+		caps := src.Capabilities()
+		c.sources = append(c.sources, WithContext(src))
+		c.caps = append(c.caps, caps)
+		c.all = c.all.Union(caps)
+	}
+	return c
+}
+
+/* SetPrecedence specifies which sources get to fill in a field of Quote
+ * first. Sources are identified by their String(). Sources that aren't
+ * mentioned come after the mentioned ones, in their original order. */
+func (c *Composite) SetPrecedence(field string, sources ...string) error {
+	if _, ok := reflect.TypeOf(Quote{}).FieldByName(field); !ok {
+		return fmt.Errorf("composite: Quote has no field '%v'", field)
+	}
+
+	order := make([]int, 0, len(c.sources))
+	used := make(map[int]bool, len(c.sources))
+	for _, name := range sources {
+		idx := c.index(name)
+		if idx < 0 {
+			return fmt.Errorf("composite: no source named '%v'", name)
+		}
+		if !used[idx] {
+			order = append(order, idx)
+			used[idx] = true
+		}
+	}
+	for idx := range c.sources {
+		if !used[idx] {
+			order = append(order, idx)
+		}
+	}
+
+	c.precedence[field] = order
+	return nil
+}
+
+/* SetIncomplete replaces the function that decides whether a quote is too
+ * incomplete to be trusted, nil restores the default (IsIncomplete). */
+func (c *Composite) SetIncomplete(fn func(q *Quote) bool) {
+	if fn == nil {
+		fn = IsIncomplete
+	}
+	c.incomplete = fn
+}
+
+func (c *Composite) Quote(symbols []string) ([]Quote, error) {
+	return c.QuoteContext(context.Background(), symbols)
+}
+
+func (c *Composite) QuoteContext(ctx context.Context, symbols []string) ([]Quote, error) {
+	if !c.Capabilities().Supports(ActionQuote) {
+		return nil, ErrNotSupported(c, ActionQuote)
+	}
+
+	/* results[i] holds the quotes of source i, per symbol */
+	results := make([]map[string]*Quote, len(c.sources))
+	errors := make([]SymbolErrors, len(c.sources))
+	c.each(ActionQuote, func(i int, src ContextSource) {
+		quotes, err := src.QuoteContext(ctx, symbols)
+		results[i] = QuotesToMap(quotes)
+		errors[i] = PerSymbol(err, symbols)
+	})
+
+	merged := make([]Quote, 0, len(symbols))
+	errs := make(SymbolErrors)
+	for _, symbol := range symbols {
+		quotes := make([]*Quote, len(c.sources))
+		found := false
+		for i := range c.sources {
+			if q, ok := results[i][symbol]; ok && !c.incomplete(q) {
+				quotes[i] = q
+				found = true
+			}
+		}
+
+		/* nothing decent came back, make do with what we have */
+		if !found {
+			for i := range c.sources {
+				if q, ok := results[i][symbol]; ok {
+					quotes[i] = q
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			c.firstError(errs, errors, symbol)
+			continue
+		}
+
+		merged = append(merged, c.merge(symbol, quotes))
+	}
+
+	return merged, errs.Err()
+}
+
+func (c *Composite) Hist(symbols []string) (map[string]Hist, error) {
+	return c.HistContext(context.Background(), symbols)
+}
+
+func (c *Composite) HistContext(ctx context.Context, symbols []string) (map[string]Hist, error) {
+	return c.hist(ActionHist, symbols, func(src ContextSource) (map[string]Hist, error) {
+		return src.HistContext(ctx, symbols)
+	})
+}
+
+func (c *Composite) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]Hist, error) {
+	return c.HistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (c *Composite) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]Hist, error) {
+	return c.hist(ActionHistLimit, symbols, func(src ContextSource) (map[string]Hist, error) {
+		return src.HistLimitContext(ctx, symbols, start, end)
+	})
+}
+
+func (c *Composite) DividendHist(symbols []string) (map[string]DividendHist, error) {
+	return c.DividendHistContext(context.Background(), symbols)
+}
+
+func (c *Composite) DividendHistContext(ctx context.Context, symbols []string) (map[string]DividendHist, error) {
+	return c.divhist(ActionDividendHist, symbols, func(src ContextSource) (map[string]DividendHist, error) {
+		return src.DividendHistContext(ctx, symbols)
+	})
+}
+
+func (c *Composite) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]DividendHist, error) {
+	return c.DividendHistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (c *Composite) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]DividendHist, error) {
+	return c.divhist(ActionDividendHistLimit, symbols, func(src ContextSource) (map[string]DividendHist, error) {
+		return src.DividendHistLimitContext(ctx, symbols, start, end)
+	})
+}
+
+/* the union of the capabilities of all underlying sources */
+func (c *Composite) Capabilities() Capabilities {
+	return c.all
+}
+
+func (c *Composite) String() string {
+	names := make([]string, 0, len(c.sources))
+	for _, src := range c.sources {
+		names = append(names, src.String())
+	}
+	return "Composite of: " + strings.Join(names, ", ")
+}
+
+func (c *Composite) hist(action Action, symbols []string, fetch func(src ContextSource) (map[string]Hist, error)) (map[string]Hist, error) {
+	results := make([]map[string]Hist, len(c.sources))
+	m := make(map[string]Hist, len(symbols))
+	err := c.first(action, symbols, func(i int, src ContextSource) (err error) {
+		results[i], err = fetch(src)
+		return err
+	}, func(i int, symbol string) (int, bool) {
+		h, ok := results[i][symbol]
+		return len(h.Entries), ok
+	}, func(i int, symbol string) {
+		m[symbol] = results[i][symbol]
+	})
+	return m, err
+}
+
+func (c *Composite) divhist(action Action, symbols []string, fetch func(src ContextSource) (map[string]DividendHist, error)) (map[string]DividendHist, error) {
+	results := make([]map[string]DividendHist, len(c.sources))
+	m := make(map[string]DividendHist, len(symbols))
+	err := c.first(action, symbols, func(i int, src ContextSource) (err error) {
+		results[i], err = fetch(src)
+		return err
+	}, func(i int, symbol string) (int, bool) {
+		h, ok := results[i][symbol]
+		return len(h.Dividends), ok
+	}, func(i int, symbol string) {
+		m[symbol] = results[i][symbol]
+	})
+	return m, err
+}
+
+/* asks every source that supports action for symbols (fetch keeps what
+ * source i returns) and picks, per symbol, the first source that came up
+ * with any entries. An empty history is still better than an error, so
+ * failing that it's the first one that returned the symbol without one.
+ * entries tells whether source i returned symbol and with how many
+ * entries, pick is told which source was picked. */
+func (c *Composite) first(action Action, symbols []string,
+	fetch func(i int, src ContextSource) error,
+	entries func(i int, symbol string) (int, bool),
+	pick func(i int, symbol string)) error {
+
+	if !c.Capabilities().Supports(action) {
+		return ErrNotSupported(c, action)
+	}
+
+	errors := make([]SymbolErrors, len(c.sources))
+	c.each(action, func(i int, src ContextSource) {
+		errors[i] = PerSymbol(fetch(i, src), symbols)
+	})
+
+	errs := make(SymbolErrors)
+	for _, symbol := range symbols {
+		picked := -1
+		for i := range c.sources {
+			if n, ok := entries(i, symbol); ok && n > 0 {
+				picked = i
+				break
+			}
+		}
+		for i := range c.sources {
+			if _, ok := entries(i, symbol); ok && picked < 0 && errors[i][symbol] == nil {
+				picked = i
+			}
+		}
+
+		if picked < 0 {
+			c.firstError(errs, errors, symbol)
+			continue
+		}
+		pick(picked, symbol)
+	}
+
+	return errs.Err()
+}
+
+/* merges the quotes of all sources into one, quotes[i] belongs to source
+ * i and may be nil */
+func (c *Composite) merge(symbol string, quotes []*Quote) Quote {
+	merged := Quote{Symbol: symbol}
+	dst := reflect.ValueOf(&merged).Elem()
+	typ := dst.Type()
+
+	for f := 0; f < typ.NumField(); f++ {
+		name := typ.Field(f).Name
+		if name == "Symbol" {
+			continue
+		}
+
+		for _, idx := range c.order(name) {
+			if quotes[idx] == nil {
+				continue
+			}
+
+			val := reflect.ValueOf(quotes[idx]).Elem().Field(f)
+			if !val.IsZero() {
+				dst.Field(f).Set(val)
+				break
+			}
+		}
+	}
+
+	return merged
+}
+
+/* the order in which sources are consulted for a field */
+func (c *Composite) order(field string) []int {
+	if order, ok := c.precedence[field]; ok {
+		return order
+	}
+
+	order := make([]int, len(c.sources))
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+func (c *Composite) index(name string) int {
+	for i, src := range c.sources {
+		if src.String() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/* records the error of the first source that had one for symbol. If none
+ * of them did, they silently left it out: they don't know it. */
+func (c *Composite) firstError(errs SymbolErrors, srcErrs []SymbolErrors, symbol string) {
+	for i := range c.sources {
+		if serr, ok := srcErrs[i][symbol]; ok {
+			errs[symbol] = serr
+			return
+		}
+	}
+	errs.Add(symbol, KindUnknownSymbol, fmt.Errorf("none of the sources returned %v", symbol))
+}
+
+/* runs fn in parallel for every source that supports action, waits until
+ * they're all done */
+func (c *Composite) each(action Action, fn func(i int, src ContextSource)) {
+	var wg sync.WaitGroup
+	for i, src := range c.sources {
+		if !c.caps[i].Supports(action) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, src ContextSource) {
+			defer wg.Done()
+			fn(i, src)
+		}(i, src)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,189 @@
+package fquery
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* a source that returns what it's given, and errors for the symbols in
+ * fail */
+type source struct {
+	name    string
+	actions []Action
+	quotes  map[string]Quote
+	hists   map[string]Hist
+	fail    map[string]ErrorKind
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *source) String() string { return s.name }
+
+func (s *source) Capabilities() Capabilities {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return Capabilities{Actions: s.actions}
+}
+
+func (s *source) errs(symbols []string) error {
+	errs := make(SymbolErrors)
+	for _, symbol := range symbols {
+		if kind, ok := s.fail[symbol]; ok {
+			errs.Add(symbol, kind, errors.New(s.name+" failed"))
+		}
+	}
+	return errs.Err()
+}
+
+func (s *source) Quote(symbols []string) ([]Quote, error) {
+	var quotes []Quote
+	for _, symbol := range symbols {
+		if q, ok := s.quotes[symbol]; ok {
+			quotes = append(quotes, q)
+		}
+	}
+	return quotes, s.errs(symbols)
+}
+
+func (s *source) Hist(symbols []string) (map[string]Hist, error) {
+	hists := make(map[string]Hist)
+	for _, symbol := range symbols {
+		if h, ok := s.hists[symbol]; ok {
+			hists[symbol] = h
+		}
+	}
+	return hists, s.errs(symbols)
+}
+
+func (s *source) HistLimit(symbols []string, start, end time.Time) (map[string]Hist, error) {
+	return s.Hist(symbols)
+}
+
+func (s *source) DividendHist(symbols []string) (map[string]DividendHist, error) {
+	return nil, ErrNotSupported(s, ActionDividendHist)
+}
+
+func (s *source) DividendHistLimit(symbols []string, start, end time.Time) (map[string]DividendHist, error) {
+	return nil, ErrNotSupported(s, ActionDividendHistLimit)
+}
+
+func TestPrecedence(t *testing.T) {
+	a := &source{name: "a", actions: []Action{ActionQuote}, quotes: map[string]Quote{
+		"X": {Symbol: "X", LastTradePrice: 1, Volume: 10, Name: "from a"},
+	}}
+	b := &source{name: "b", actions: []Action{ActionQuote}, quotes: map[string]Quote{
+		"X": {Symbol: "X", LastTradePrice: 2, Volume: 20, PeRatio: 15},
+	}}
+
+	c := NewComposite(a, b)
+	if err := c.SetPrecedence("Volume", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if c.SetPrecedence("Nope", "b") == nil || c.SetPrecedence("Volume", "c") == nil {
+		t.Errorf("unknown fields and sources should be refused")
+	}
+
+	quotes, err := c.Quote([]string{"X"})
+	if err != nil || len(quotes) != 1 {
+		t.Fatalf("got %v, %v", quotes, err)
+	}
+
+	/* the order of the sources, except for the volume. Fields only one
+	 * source has come from that one. */
+	q := quotes[0]
+	if q.LastTradePrice != 1 || q.Volume != 20 || q.Name != "from a" || q.PeRatio != 15 {
+		t.Errorf("wrongly merged: %+v", q)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	a := &source{name: "a", actions: []Action{ActionQuote}, quotes: map[string]Quote{
+		"X": {Symbol: "X", Name: "no price"},
+		"Y": {Symbol: "Y", Name: "no price either"},
+	}}
+	b := &source{name: "b", actions: []Action{ActionQuote}, quotes: map[string]Quote{
+		"X": {Symbol: "X", LastTradePrice: 2},
+	}}
+
+	quotes, err := NewComposite(a, b).Quote([]string{"X", "Y"})
+	if err != nil || len(quotes) != 2 {
+		t.Fatalf("got %v, %v", quotes, err)
+	}
+
+	/* the incomplete quote of a is ignored when b has a decent one, but
+	 * it's better than nothing */
+	if q := quotes[0]; q.Name != "" || q.LastTradePrice != 2 {
+		t.Errorf("the incomplete quote was merged in: %+v", q)
+	}
+	if q := quotes[1]; q.Name != "no price either" {
+		t.Errorf("the incomplete quote wasn't used as a last resort: %+v", q)
+	}
+}
+
+func TestErrorAttribution(t *testing.T) {
+	a := &source{name: "a", actions: []Action{ActionQuote, ActionHist},
+		quotes: map[string]Quote{"OK": {Symbol: "OK", LastTradePrice: 1}},
+		hists:  map[string]Hist{"EMPTY": {Symbol: "EMPTY"}},
+		fail:   map[string]ErrorKind{"X": KindNetwork, "Y": KindLayout},
+	}
+	b := &source{name: "b", actions: []Action{ActionQuote, ActionHist},
+		quotes: map[string]Quote{"Y": {Symbol: "Y", LastTradePrice: 1}},
+		fail:   map[string]ErrorKind{"X": KindUnknownSymbol},
+	}
+	c := NewComposite(a, b)
+
+	quotes, err := c.Quote([]string{"OK", "X", "Y", "GONE"})
+	if len(quotes) != 2 {
+		t.Errorf("got %v quotes, want 2", len(quotes))
+	}
+
+	/* the error of the first source counts, symbols nobody returned or
+	 * had an error for are unknown. Y came from b, a's error doesn't
+	 * matter. */
+	errs, ok := err.(SymbolErrors)
+	if !ok {
+		t.Fatalf("got %v", err)
+	}
+	if len(errs) != 2 || errs.Kind("X") != KindNetwork || errs.Kind("GONE") != KindUnknownSymbol {
+		t.Errorf("got %v", errs)
+	}
+
+	/* an empty history without an error is no error */
+	hists, err := c.Hist([]string{"EMPTY", "GONE"})
+	if _, ok := hists["EMPTY"]; !ok {
+		t.Errorf("the empty history is gone")
+	}
+	if errs, ok := err.(SymbolErrors); !ok || len(errs) != 1 || errs.Kind("GONE") != KindUnknownSymbol {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	a := &source{name: "a", actions: []Action{ActionQuote}}
+	_, err := NewComposite(a).Hist([]string{"X"})
+	if KindOf(err) != KindNotSupported {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCompositeCapabilities(t *testing.T) {
+	a := &source{name: "a", actions: []Action{ActionQuote}}
+	b := &source{name: "b", actions: []Action{ActionHist, ActionQuote}}
+	c := NewComposite(a, b)
+
+	caps := c.Capabilities()
+	if !caps.Supports(ActionQuote) || !caps.Supports(ActionHist) || caps.Supports(ActionHistLimit) {
+		t.Errorf("got %+v", caps)
+	}
+
+	/* asked once, when the composite is made */
+	c.Quote([]string{"X"})
+	c.Hist([]string{"X"})
+	if a.calls != 1 || b.calls != 1 {
+		t.Errorf("capabilities asked %v and %v times, want once", a.calls, b.calls)
+	}
+}
@@ -0,0 +1,103 @@
+package fquery
+
+import (
+	"context"
+	"time"
+)
+
+/* ContextSource is a Source whose fetches can be cancelled. Every method
+ * returns as soon as ctx is done, with whatever results were gathered up
+ * until that point and ctx.Err(). */
+type ContextSource interface {
+	Source
+
+	QuoteContext(ctx context.Context, symbols []string) ([]Quote, error)
+
+	HistContext(ctx context.Context, symbols []string) (map[string]Hist, error)
+	HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]Hist, error)
+
+	DividendHistContext(ctx context.Context, symbols []string) (map[string]DividendHist, error)
+	DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]DividendHist, error)
+}
+
+/* WithContext returns src itself if it already knows how to deal with
+ * contexts. Otherwise it wraps src so that callers at least stop waiting
+ * when ctx is done, the call to the underlying source will keep running
+ * in the background until it finishes by itself. */
+func WithContext(src Source) ContextSource {
+	if csrc, ok := src.(ContextSource); ok {
+		return csrc
+	}
+	return &ctxSource{src}
+}
+
+type ctxSource struct {
+	Source
+}
+
+func (s *ctxSource) QuoteContext(ctx context.Context, symbols []string) ([]Quote, error) {
+	res, err := wait(ctx, func() (interface{}, error) {
+		return s.Source.Quote(symbols)
+	})
+	quotes, _ := res.([]Quote)
+	return quotes, err
+}
+
+func (s *ctxSource) HistContext(ctx context.Context, symbols []string) (map[string]Hist, error) {
+	res, err := wait(ctx, func() (interface{}, error) {
+		return s.Source.Hist(symbols)
+	})
+	hist, _ := res.(map[string]Hist)
+	return hist, err
+}
+
+func (s *ctxSource) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]Hist, error) {
+	res, err := wait(ctx, func() (interface{}, error) {
+		return s.Source.HistLimit(symbols, start, end)
+	})
+	hist, _ := res.(map[string]Hist)
+	return hist, err
+}
+
+func (s *ctxSource) DividendHistContext(ctx context.Context, symbols []string) (map[string]DividendHist, error) {
+	res, err := wait(ctx, func() (interface{}, error) {
+		return s.Source.DividendHist(symbols)
+	})
+	hist, _ := res.(map[string]DividendHist)
+	return hist, err
+}
+
+func (s *ctxSource) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]DividendHist, error) {
+	res, err := wait(ctx, func() (interface{}, error) {
+		return s.Source.DividendHistLimit(symbols, start, end)
+	})
+	hist, _ := res.(map[string]DividendHist)
+	return hist, err
+}
+
+/* runs fn in the background and waits for it to finish or for ctx to be
+ * done, whichever comes first. In the latter case the result of fn is
+ * thrown away once it arrives. */
+func wait(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		val interface{}
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		val, err := fn()
+		done <- result{val, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.val, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package fquery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+)
+
+/* ErrorKind broadly classifies why fetching a symbol failed, so callers
+ * can decide whether retrying makes sense (network), whether the input
+ * was wrong (unknown symbol) or whether a source needs fixing (layout). */
+type ErrorKind int
+
+const (
+	KindOther         ErrorKind = iota
+	KindUnknownSymbol           /* the source doesn't know the symbol */
+	KindNetwork                 /* the source couldn't be reached */
+	KindLayout                  /* the source answered, but not in a format we understand */
+	KindCanceled                /* the request was cancelled or timed out */
+	KindNotSupported            /* the source can't do what was asked */
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindUnknownSymbol:
+		return "symbol unknown"
+	case KindNetwork:
+		return "network failure"
+	case KindLayout:
+		return "page layout changed"
+	case KindCanceled:
+		return "cancelled"
+	case KindNotSupported:
+		return "not supported"
+	default:
+		return "error"
+	}
+}
+
+/* SymbolError is the reason a single symbol could not be fetched. */
+type SymbolError struct {
+	Symbol string
+	Kind   ErrorKind
+	Err    error
+}
+
+func NewSymbolError(symbol string, kind ErrorKind, err error) *SymbolError {
+	return &SymbolError{Symbol: symbol, Kind: kind, Err: err}
+}
+
+func (e *SymbolError) Error() string {
+	return e.Symbol + ": " + e.Kind.String() + ", " + e.Err.Error()
+}
+
+func (e *SymbolError) Unwrap() error {
+	return e.Err
+}
+
+/* SymbolErrors is returned next to partial results when some (or all) of
+ * the requested symbols could not be fetched. The results that did come
+ * through are still valid. Don't return an empty SymbolErrors as an
+ * error, use Err() for that. */
+type SymbolErrors map[string]*SymbolError
+
+/* Add records err for symbol, if err is nil nothing happens. */
+func (e SymbolErrors) Add(symbol string, kind ErrorKind, err error) {
+	if err == nil {
+		return
+	}
+	e[symbol] = NewSymbolError(symbol, kind, err)
+}
+
+/* Merge adds the errors contained in err. Errors that aren't about a
+ * specific symbol are attributed to all of the passed symbols. */
+func (e SymbolErrors) Merge(err error, symbols ...string) {
+	switch err := err.(type) {
+	case nil:
+		return
+	case SymbolErrors:
+		for symbol, serr := range err {
+			e[symbol] = serr
+		}
+	case *SymbolError:
+		e[err.Symbol] = err
+	default:
+		kind := KindOf(err)
+		for _, symbol := range symbols {
+			e.Add(symbol, kind, err)
+		}
+	}
+}
+
+/* Err returns e if it contains at least one error, nil otherwise. */
+func (e SymbolErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+/* Symbols returns the failed symbols, sorted. */
+func (e SymbolErrors) Symbols() []string {
+	symbols := make([]string, 0, len(e))
+	for symbol := range e {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+/* Kind returns the kind of the error that occurred for symbol, or
+ * KindOther if there was no error. */
+func (e SymbolErrors) Kind(symbol string) ErrorKind {
+	if serr, ok := e[symbol]; ok {
+		return serr.Kind
+	}
+	return KindOther
+}
+
+func (e SymbolErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, symbol := range e.Symbols() {
+		msgs = append(msgs, e[symbol].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+/* Unwrap allows errors.Is(err, context.DeadlineExceeded) and friends to
+ * look inside. */
+func (e SymbolErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, symbol := range e.Symbols() {
+		errs = append(errs, e[symbol])
+	}
+	return errs
+}
+
+/* PerSymbol turns err into SymbolErrors, errors that don't belong to a
+ * single symbol are attributed to all of symbols. Returns nil if err is
+ * nil. */
+func PerSymbol(err error, symbols []string) SymbolErrors {
+	if err == nil {
+		return nil
+	}
+	errs := make(SymbolErrors)
+	errs.Merge(err, symbols...)
+	return errs
+}
+
+/* KindOf makes an educated guess as to what kind of error err is. */
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindOther
+	}
+
+	var serr *SymbolError
+	if errors.As(err, &serr) {
+		return serr.Kind
+	}
+
+	var nserr *NotSupportedError
+	if errors.As(err, &nserr) {
+		return KindNotSupported
+	}
+
+	/* check this before net.Error, a cancelled HTTP request is one too */
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return KindCanceled
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return KindNetwork
+	}
+
+	return KindOther
+}
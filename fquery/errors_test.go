@@ -0,0 +1,75 @@
+package fquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	errs := make(SymbolErrors)
+	errs.Merge(nil, "A")
+	if errs.Err() != nil {
+		t.Fatalf("merging nil added %v", errs)
+	}
+
+	/* errors of other symbols are taken over as they are */
+	other := make(SymbolErrors)
+	other.Add("A", KindUnknownSymbol, errors.New("no such symbol"))
+	errs.Merge(other, "A", "B")
+	errs.Merge(NewSymbolError("B", KindLayout, errors.New("changed")), "A", "B")
+	if errs.Kind("A") != KindUnknownSymbol || errs.Kind("B") != KindLayout {
+		t.Errorf("got kinds %v and %v", errs.Kind("A"), errs.Kind("B"))
+	}
+
+	/* an error of the whole request goes to every symbol of it */
+	errs.Merge(context.DeadlineExceeded, "C", "D")
+	for _, symbol := range []string{"C", "D"} {
+		if errs.Kind(symbol) != KindCanceled {
+			t.Errorf("%v: got %v, want %v", symbol, errs.Kind(symbol), KindCanceled)
+		}
+	}
+
+	if got, want := fmt.Sprint(errs.Symbols()), "[A B C D]"; got != want {
+		t.Errorf("symbols: got %v, want %v", got, want)
+	}
+	if !errors.Is(errs, context.DeadlineExceeded) {
+		t.Errorf("errors.Is doesn't look inside")
+	}
+	if errs.Kind("E") != KindOther {
+		t.Errorf("a symbol without an error has kind %v", errs.Kind("E"))
+	}
+}
+
+func TestPerSymbol(t *testing.T) {
+	if errs := PerSymbol(nil, []string{"A"}); errs != nil {
+		t.Errorf("got %v for no error", errs)
+	}
+
+	errs := PerSymbol(errors.New("boom"), []string{"A", "B"})
+	if len(errs) != 2 || errs.Kind("A") != KindOther || errs.Kind("B") != KindOther {
+		t.Errorf("got %v", errs)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	cases := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{nil, KindOther},
+		{errors.New("plain"), KindOther},
+		{fmt.Errorf("wrapped: %w", NewSymbolError("A", KindLayout, errors.New("x"))), KindLayout},
+		{fmt.Errorf("wrapped: %w", &NotSupportedError{"test", ActionHist}), KindNotSupported},
+		{fmt.Errorf("wrapped: %w", context.Canceled), KindCanceled},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, KindNetwork},
+		{&net.OpError{Op: "dial", Err: context.DeadlineExceeded}, KindCanceled},
+	}
+	for _, c := range cases {
+		if got := KindOf(c.err); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.err, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package fquery
+
+import (
+	"context"
+)
+
+/* FundInfo contains the information that is specific to funds and ETFs.
+ * All weights are fractions (0.25 = 25%). */
+type FundInfo struct {
+	Symbol   string
+	Name     string
+	Category string
+
+	Benchmark         string /* the index the fund itself tracks or compares to */
+	CategoryBenchmark string /* the index the source assigns to the category */
+
+	Ter float64 /* total expense ratio */
+
+	Holdings []Holding          /* top holdings, largest first */
+	Sectors  map[string]float64 /* sector -> weight */
+	Regions  map[string]float64 /* region -> weight */
+}
+
+type Holding struct {
+	Name   string
+	Symbol string
+	Weight float64
+}
+
+/* FundSource is implemented by sources that know more about funds than
+ * what fits in a Quote. */
+type FundSource interface {
+	Fund(symbols []string) (map[string]FundInfo, error)
+	FundContext(ctx context.Context, symbols []string) (map[string]FundInfo, error)
+}
@@ -0,0 +1,56 @@
+package fquery
+
+import "context"
+
+/* Parallel runs fetch for every symbol (once, duplicates are skipped) in
+ * parallel and hands the results to collect, never concurrently. It stops
+ * waiting when ctx is done, the symbols that didn't come back by then get
+ * a KindCanceled error. Errors are filed under the symbol fetch was
+ * called with and classified with KindOf, unless they already are a
+ * *SymbolError. */
+func Parallel(ctx context.Context, symbols []string,
+	fetch func(symbol string) (interface{}, error),
+	collect func(symbol string, v interface{})) SymbolErrors {
+
+	type result struct {
+		symbol string
+		val    interface{}
+		err    error
+	}
+
+	errs := make(SymbolErrors)
+	pending := make(map[string]bool, len(symbols))
+	results := make(chan result, len(symbols))
+	for _, symbol := range symbols {
+		if pending[symbol] {
+			continue
+		}
+		pending[symbol] = true
+
+		go func(symbol string) {
+			val, err := fetch(symbol)
+			results <- result{symbol, val, err}
+		}(symbol)
+	}
+
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.symbol)
+			if serr, ok := r.err.(*SymbolError); ok {
+				errs.Add(r.symbol, serr.Kind, serr.Err)
+			} else if r.err != nil {
+				errs.Add(r.symbol, KindOf(r.err), r.err)
+			} else {
+				collect(r.symbol, r.val)
+			}
+		case <-ctx.Done():
+			for symbol := range pending {
+				errs.Add(symbol, KindCanceled, ctx.Err())
+			}
+			return errs
+		}
+	}
+
+	return errs
+}
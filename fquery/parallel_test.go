@@ -0,0 +1,60 @@
+package fquery
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallel(t *testing.T) {
+	var calls int32
+	got := make(map[string]interface{})
+	errs := Parallel(context.Background(), []string{"A", "B", "A", "C", "D"},
+		func(symbol string) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			switch symbol {
+			case "B":
+				/* filed under the symbol asked for, not the one in the error */
+				return nil, NewSymbolError("B:US", KindUnknownSymbol, errors.New("no such symbol"))
+			case "C":
+				return nil, context.DeadlineExceeded
+			}
+			return symbol + symbol, nil
+		}, func(symbol string, v interface{}) {
+			got[symbol] = v
+		})
+
+	if calls != 4 {
+		t.Errorf("fetched %v times, want once per symbol", calls)
+	}
+	if len(got) != 2 || got["A"] != "AA" || got["D"] != "DD" {
+		t.Errorf("collected %v", got)
+	}
+	if len(errs) != 2 || errs.Kind("B") != KindUnknownSymbol || errs.Kind("C") != KindCanceled {
+		t.Errorf("got %v", errs)
+	}
+	if errs["B"].Symbol != "B" {
+		t.Errorf("the error of B is filed as %v", errs["B"].Symbol)
+	}
+}
+
+func TestParallelCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan struct{})
+	defer close(block)
+
+	errs := Parallel(ctx, []string{"A", "B"}, func(symbol string) (interface{}, error) {
+		if symbol == "A" {
+			return symbol, nil
+		}
+		cancel()
+		<-block
+		return symbol, nil
+	}, func(symbol string, v interface{}) {})
+
+	/* A may or may not have made it, B can't have */
+	if errs.Kind("B") != KindCanceled {
+		t.Errorf("got %v", errs)
+	}
+}
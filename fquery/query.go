@@ -29,6 +29,12 @@ type Quote struct {
 	DividendPerShare float64   /* total (non-special) dividend payout / total amount of shares */
 	DividendYield    float64   /* annual div. per share / price per share */
 	DividendExDate   time.Time /* last dividend payout date */
+	DividendGrowth5y float64   /* yearly growth of the dividend over the last 5 years */
+
+	/* estimates & relative */
+	PeRatioEst          float64 /* price / estimated EPS of the current year */
+	PeRatioRelToIndex   float64 /* P/E / P/E of the index the stock belongs to */
+	EarningsPerShareEst float64 /* estimated EPS of the current year */
 
 	/* price & derived */
 	Bid, Ask            float64
@@ -39,6 +45,22 @@ type Quote struct {
 	YearLow, YearHigh float64
 
 	Ma50, Ma200 float64 /* 200- and 50-day moving average */
+	YearReturn  float64 /* price change over the last year, as a fraction */
+
+	/* funds & ETFs only, DividendYield is the yield of the fund itself */
+	FundType     string  /* e.g.: ETF, Open-End Fund, ... */
+	Nav          float64 /* net asset value per share */
+	ExpenseRatio float64 /* total yearly costs / assets */
+	TotalAssets  float64 /* in the currency of the fund */
+
+	/* set by caches that hand out an expired quote because it couldn't be
+	 * refreshed: how old it is. Zero for fresh quotes. */
+	Stale time.Duration `db:"-"`
+}
+
+/* whether the quote belongs to a fund or ETF, as opposed to a stock */
+func (q *Quote) IsFund() bool {
+	return q.FundType != "" || q.Nav != 0
 }
 
 /* will try to calculate the dividend payout ratio, if possible,
@@ -60,6 +82,11 @@ type Hist struct {
 	From    time.Time
 	To      time.Time
 	Entries []HistEntry
+
+	/* set by caches that hand out history that couldn't be brought up to
+	 * date: the time since the last day it's complete for. Zero for fresh
+	 * history. */
+	Stale time.Duration
 }
 
 type DividendHist struct {
@@ -91,5 +118,8 @@ type Source interface {
 	DividendHist(symbols []string) (map[string]DividendHist, error)
 	DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]DividendHist, error)
 
+	/* what the source is able to deliver, see Capabilities */
+	Capabilities() Capabilities
+
 	fmt.Stringer
 }
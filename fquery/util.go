@@ -2,8 +2,8 @@ package fquery
 
 func QuotesToMap(quotes []Quote) map[string]*Quote {
 	m := make(map[string]*Quote)
-	for _, quote := range quotes {
-		m[quote.Symbol] = &quote
+	for i := range quotes {
+		m[quotes[i].Symbol] = &quotes[i]
 	}
 	return m
 }
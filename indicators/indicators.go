@@ -0,0 +1,287 @@
+package indicators
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+/* Point is the value of an indicator on a day. Days the indicator isn't
+ * defined for yet (the first n-1 of a n day average, for example) are
+ * NaN. */
+type Point struct {
+	Date  time.Time
+	Value float64
+}
+
+/* Series has a Point for every entry of the history it was calculated
+ * from, oldest first, so that series of the same history line up. */
+type Series []Point
+
+/* Defined returns the series from the first day it has a value */
+func (s Series) Defined() Series {
+	for i, p := range s {
+		if !math.IsNaN(p.Value) {
+			return s[i:]
+		}
+	}
+	return nil
+}
+
+/* Last returns the most recent value, false if there is none */
+func (s Series) Last() (Point, bool) {
+	if len(s) == 0 || math.IsNaN(s[len(s)-1].Value) {
+		return Point{}, false
+	}
+	return s[len(s)-1], true
+}
+
+/* At returns the value on day, NaN if it's not in the series */
+func (s Series) At(day time.Time) float64 {
+	i := sort.Search(len(s), func(i int) bool { return !s[i].Date.Before(day) })
+	if i < len(s) && s[i].Date.Equal(day) {
+		return s[i].Value
+	}
+	return math.NaN()
+}
+
+/* the entries of h, oldest first: not every source returns them in the
+ * same order */
+func entries(h fquery.Hist) []fquery.HistEntry {
+	es := append([]fquery.HistEntry(nil), h.Entries...)
+	sort.SliceStable(es, func(i, j int) bool {
+		return es[i].Date.GetTime().Before(es[j].Date.GetTime())
+	})
+	return es
+}
+
+func closes(es []fquery.HistEntry) []float64 {
+	xs := make([]float64, len(es))
+	for i, e := range es {
+		xs[i] = e.Close
+	}
+	return xs
+}
+
+func series(es []fquery.HistEntry, xs []float64) Series {
+	s := make(Series, len(es))
+	for i, e := range es {
+		s[i] = Point{e.Date.GetTime(), xs[i]}
+	}
+	return s
+}
+
+func nans(n int) []float64 {
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = math.NaN()
+	}
+	return xs
+}
+
+/* the index of the first value that's defined, len(xs) if none is. The
+ * internal series are only ever undefined at the start. */
+func start(xs []float64) int {
+	for i, x := range xs {
+		if !math.IsNaN(x) {
+			return i
+		}
+	}
+	return len(xs)
+}
+
+/* SMA is the simple moving average of the closes over n days */
+func SMA(h fquery.Hist, n int) Series {
+	es := entries(h)
+	return series(es, sma(closes(es), n))
+}
+
+func sma(xs []float64, n int) []float64 {
+	res := nans(len(xs))
+	if n <= 0 {
+		return res
+	}
+
+	var (
+		sum   float64
+		first = start(xs)
+	)
+	for i := first; i < len(xs); i++ {
+		sum += xs[i]
+		if i-n >= first {
+			sum -= xs[i-n]
+		}
+		if i-n+1 >= first {
+			res[i] = sum / float64(n)
+		}
+	}
+	return res
+}
+
+/* EMA is the exponential moving average of the closes over n days, with
+ * a weight of 2/(n+1) for the newest close. It starts out as the simple
+ * average of the first n days. */
+func EMA(h fquery.Hist, n int) Series {
+	es := entries(h)
+	return series(es, ema(closes(es), n))
+}
+
+func ema(xs []float64, n int) []float64 {
+	return smooth(xs, n, 2/float64(n+1))
+}
+
+/* Wilder's smoothing, as RSI and ATR use it, is an EMA with a weight of
+ * 1/n */
+func wilder(xs []float64, n int) []float64 {
+	return smooth(xs, n, 1/float64(n))
+}
+
+/* an exponential average with weight alpha, seeded with the simple
+ * average of the first n values that are defined */
+func smooth(xs []float64, n int, alpha float64) []float64 {
+	res := nans(len(xs))
+	first := start(xs)
+	if n <= 0 || first+n > len(xs) {
+		return res
+	}
+
+	var sum float64
+	for _, x := range xs[first : first+n] {
+		sum += x
+	}
+	avg := sum / float64(n)
+	res[first+n-1] = avg
+	for i := first + n; i < len(xs); i++ {
+		avg += alpha * (xs[i] - avg)
+		res[i] = avg
+	}
+	return res
+}
+
+/* RSI is Wilder's relative strength index of the closes over n days (14
+ * is usual), from 0 to 100 */
+func RSI(h fquery.Hist, n int) Series {
+	es := entries(h)
+	xs := closes(es)
+
+	gains, losses := nans(len(xs)), nans(len(xs))
+	for i := 1; i < len(xs); i++ {
+		d := xs[i] - xs[i-1]
+		gains[i], losses[i] = math.Max(d, 0), math.Max(-d, 0)
+	}
+
+	gain, loss := wilder(gains, n), wilder(losses, n)
+	res := nans(len(xs))
+	for i := range res {
+		switch {
+		case math.IsNaN(gain[i]):
+		case loss[i] == 0 && gain[i] == 0:
+			res[i] = 50
+		case loss[i] == 0:
+			res[i] = 100
+		default:
+			res[i] = 100 - 100/(1+gain[i]/loss[i])
+		}
+	}
+	return series(es, res)
+}
+
+/* MACD is the difference between the fast and slow EMA of the closes
+ * (12 and 26 days are usual), the signal line is the EMA of that
+ * difference (usually over 9 days) and the histogram what the difference
+ * is above the signal. */
+func MACD(h fquery.Hist, fast, slow, signal int) (macd, sig, hist Series) {
+	es := entries(h)
+	xs := closes(es)
+
+	f, s := ema(xs, fast), ema(xs, slow)
+	line := make([]float64, len(xs))
+	for i := range line {
+		line[i] = f[i] - s[i]
+	}
+
+	sl := ema(line, signal)
+	diff := make([]float64, len(xs))
+	for i := range diff {
+		diff[i] = line[i] - sl[i]
+	}
+	return series(es, line), series(es, sl), series(es, diff)
+}
+
+/* Bollinger returns the simple moving average of the closes over n days
+ * (usually 20), with bands k (usually 2) standard deviations above and
+ * below it */
+func Bollinger(h fquery.Hist, n int, k float64) (middle, upper, lower Series) {
+	es := entries(h)
+	xs := closes(es)
+
+	mid := sma(xs, n)
+	up, low := nans(len(xs)), nans(len(xs))
+	for i := range xs {
+		if math.IsNaN(mid[i]) {
+			continue
+		}
+
+		var ss float64
+		for _, x := range xs[i-n+1 : i+1] {
+			ss += (x - mid[i]) * (x - mid[i])
+		}
+		sd := math.Sqrt(ss / float64(n))
+		up[i], low[i] = mid[i]+k*sd, mid[i]-k*sd
+	}
+	return series(es, mid), series(es, up), series(es, low)
+}
+
+/* ATR is Wilder's average true range over n days (usually 14). The true
+ * range of a day is how far the price moved from the close of the day
+ * before, for the first day it's just the high minus the low. */
+func ATR(h fquery.Hist, n int) Series {
+	es := entries(h)
+
+	tr := make([]float64, len(es))
+	for i, e := range es {
+		tr[i] = e.High - e.Low
+		if i > 0 {
+			prev := es[i-1].Close
+			tr[i] = math.Max(tr[i], math.Max(math.Abs(e.High-prev), math.Abs(e.Low-prev)))
+		}
+	}
+	return series(es, wilder(tr, n))
+}
+
+/* ROC is the rate of change of the closes over n days, as a fraction
+ * (like fquery.Quote.YearReturn): 0.1 means the price went up by 10%. */
+func ROC(h fquery.Hist, n int) Series {
+	es := entries(h)
+	xs := closes(es)
+
+	res := nans(len(xs))
+	for i := n; n > 0 && i < len(xs); i++ {
+		if xs[i-n] != 0 {
+			res[i] = (xs[i] - xs[i-n]) / xs[i-n]
+		}
+	}
+	return series(es, res)
+}
+
+/* FillMovingAverages sets Ma50 and Ma200 of q to the simple moving
+ * averages of h when the source didn't provide them, as long as h is
+ * long enough. Returns whether it changed anything. */
+func FillMovingAverages(q *fquery.Quote, h fquery.Hist) bool {
+	filled := false
+	for _, ma := range []struct {
+		n     int
+		value *float64
+	}{{50, &q.Ma50}, {200, &q.Ma200}} {
+		if *ma.value != 0 {
+			continue
+		}
+		if p, ok := SMA(h, ma.n).Last(); ok {
+			*ma.value = p.Value
+			filled = true
+		}
+	}
+	return filled
+}
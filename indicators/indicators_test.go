@@ -0,0 +1,147 @@
+package indicators
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+func day(i int) time.Time {
+	return time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i)
+}
+
+/* a history with these closes, a day apart, newest first like Yahoo
+ * gives them. The high and low are 1 above and below the close. */
+func hist(closes ...float64) fquery.Hist {
+	h := fquery.Hist{Symbol: "TEST"}
+	for i := len(closes) - 1; i >= 0; i-- {
+		h.Entries = append(h.Entries, fquery.HistEntry{
+			Date:  util.YearMonthDay(day(i)),
+			Close: closes[i],
+			High:  closes[i] + 1,
+			Low:   closes[i] - 1,
+		})
+	}
+	return h
+}
+
+func linear(n int) []float64 {
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = float64(i + 1)
+	}
+	return xs
+}
+
+/* compares a series to the values it should have, NaN for undefined */
+func check(t *testing.T, name string, s Series, want ...float64) {
+	t.Helper()
+	if len(s) != len(want) {
+		t.Fatalf("%v: got %v points, want %v", name, len(s), len(want))
+	}
+	for i, p := range s {
+		if !p.Date.Equal(day(i)) {
+			t.Errorf("%v: point %v is on %v, want %v", name, i, p.Date, day(i))
+		}
+		switch {
+		case math.IsNaN(want[i]) != math.IsNaN(p.Value):
+			t.Errorf("%v: point %v is %v, want %v", name, i, p.Value, want[i])
+		case !math.IsNaN(want[i]) && math.Abs(p.Value-want[i]) > 1e-9:
+			t.Errorf("%v: point %v is %v, want %v", name, i, p.Value, want[i])
+		}
+	}
+}
+
+var nan = math.NaN()
+
+func TestSMA(t *testing.T) {
+	check(t, "sma", SMA(hist(1, 2, 3, 4, 5), 3), nan, nan, 2, 3, 4)
+	check(t, "too short", SMA(hist(1, 2), 3), nan, nan)
+}
+
+func TestEMA(t *testing.T) {
+	/* seeded with the average of the first 3, then a weight of 1/2 */
+	check(t, "ema", EMA(hist(1, 2, 3, 5, 1), 3), nan, nan, 2, 3.5, 2.25)
+}
+
+func TestRSI(t *testing.T) {
+	check(t, "rsi", RSI(hist(1, 2, 1, 2, 1), 2), nan, nan, 50, 75, 37.5)
+	check(t, "only up", RSI(hist(1, 2, 3, 4), 2), nan, nan, 100, 100)
+	check(t, "flat", RSI(hist(1, 1, 1), 2), nan, nan, 50)
+}
+
+func TestMACD(t *testing.T) {
+	/* an EMA lags (n-1)/2 behind a straight line, so the difference
+	 * between the 12 and 26 day EMA is constant */
+	macd, sig, hist := MACD(hist(linear(40)...), 12, 26, 9)
+
+	if first := len(macd) - len(macd.Defined()); first != 25 {
+		t.Errorf("macd starts at %v, want 25", first)
+	}
+	if first := len(sig) - len(sig.Defined()); first != 33 {
+		t.Errorf("signal starts at %v, want 33", first)
+	}
+	for i := 33; i < 40; i++ {
+		if math.Abs(macd[i].Value-7) > 1e-9 || math.Abs(sig[i].Value-7) > 1e-9 || math.Abs(hist[i].Value) > 1e-9 {
+			t.Errorf("day %v: macd %v, signal %v, histogram %v", i, macd[i].Value, sig[i].Value, hist[i].Value)
+		}
+	}
+}
+
+func TestBollinger(t *testing.T) {
+	/* mean 5, standard deviation 2 */
+	mid, up, low := Bollinger(hist(2, 4, 4, 4, 5, 5, 7, 9), 8, 2)
+	if p, _ := mid.Last(); p.Value != 5 {
+		t.Errorf("middle: got %v, want 5", p.Value)
+	}
+	if p, _ := up.Last(); p.Value != 9 {
+		t.Errorf("upper: got %v, want 9", p.Value)
+	}
+	if p, _ := low.Last(); p.Value != 1 {
+		t.Errorf("lower: got %v, want 1", p.Value)
+	}
+}
+
+func TestATR(t *testing.T) {
+	/* the gap from 1 to 5 makes for a true range of 5 on the third day */
+	check(t, "atr", ATR(hist(1, 1, 5, 5), 2), nan, 2, 3.5, 2.75)
+}
+
+func TestROC(t *testing.T) {
+	check(t, "roc", ROC(hist(100, 50, 110, 75), 2), nan, nan, 0.1, 0.5)
+}
+
+func TestSeries(t *testing.T) {
+	s := SMA(hist(1, 2, 3, 4), 2)
+	if got := len(s.Defined()); got != 3 {
+		t.Errorf("defined: got %v points, want 3", got)
+	}
+	if got := s.At(day(2)); got != 2.5 {
+		t.Errorf("at: got %v, want 2.5", got)
+	}
+	if got := s.At(day(10)); !math.IsNaN(got) {
+		t.Errorf("at a day that's not there: got %v", got)
+	}
+	if _, ok := SMA(hist(1), 2).Last(); ok {
+		t.Errorf("last of an undefined series")
+	}
+}
+
+func TestFillMovingAverages(t *testing.T) {
+	h := hist(linear(200)...)
+
+	q := fquery.Quote{Symbol: "TEST"}
+	if !FillMovingAverages(&q, h) || q.Ma50 != 175.5 || q.Ma200 != 100.5 {
+		t.Errorf("got ma50 %v, ma200 %v, want 175.5 and 100.5", q.Ma50, q.Ma200)
+	}
+
+	/* what the source gave is kept, a history that's too short fills
+	 * nothing */
+	q = fquery.Quote{Symbol: "TEST", Ma50: 1}
+	if FillMovingAverages(&q, hist(linear(100)...)) || q.Ma50 != 1 || q.Ma200 != 0 {
+		t.Errorf("got ma50 %v, ma200 %v, want 1 and 0", q.Ma50, q.Ma200)
+	}
+}
@@ -0,0 +1,118 @@
+package memcache
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+type kind int
+
+const (
+	kindQuote kind = iota
+	kindHist
+	kindDividends
+)
+
+type key struct {
+	kind   kind
+	symbol string
+}
+
+/* one cached answer, only the field belonging to its kind is filled in */
+type entry struct {
+	key     key
+	fetched time.Time
+
+	quote fquery.Quote
+	hist  fquery.Hist
+	divs  fquery.DividendHist
+}
+
+/* a quote counts as one, histories as the amount of days they contain,
+ * that's where the memory goes */
+func (e *entry) size() int {
+	switch e.key.kind {
+	case kindHist:
+		return 1 + len(e.hist.Entries)
+	case kindDividends:
+		return 1 + len(e.divs.Dividends)
+	}
+	return 1
+}
+
+/* a plain least recently used list, not safe for concurrent use. Entries
+ * are evicted from the back until both limits are respected, a limit of
+ * 0 means there is none. */
+type lru struct {
+	maxItems int
+	maxSize  int
+
+	size  int
+	ll    *list.List
+	items map[key]*list.Element
+}
+
+func newLru(maxItems, maxSize int) *lru {
+	return &lru{
+		maxItems: maxItems,
+		maxSize:  maxSize,
+		ll:       list.New(),
+		items:    make(map[key]*list.Element),
+	}
+}
+
+/* looks k up and marks it as recently used */
+func (l *lru) get(k key) (*entry, bool) {
+	el, ok := l.items[k]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+/* looks k up without touching the order */
+func (l *lru) peek(k key) (*entry, bool) {
+	el, ok := l.items[k]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*entry), true
+}
+
+func (l *lru) add(e *entry) {
+	if el, ok := l.items[e.key]; ok {
+		l.size -= el.Value.(*entry).size()
+		el.Value = e
+		l.ll.MoveToFront(el)
+	} else {
+		l.items[e.key] = l.ll.PushFront(e)
+	}
+	l.size += e.size()
+
+	for l.ll.Len() > 0 && l.full() {
+		l.remove(l.ll.Back())
+	}
+}
+
+func (l *lru) full() bool {
+	return (l.maxItems > 0 && l.ll.Len() > l.maxItems) ||
+		(l.maxSize > 0 && l.size > l.maxSize)
+}
+
+func (l *lru) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	vprintln("memcache: evicting", e.key.symbol)
+	l.ll.Remove(el)
+	delete(l.items, e.key)
+	l.size -= e.size()
+}
+
+/* calls fn for every entry, most recently used first */
+func (l *lru) each(fn func(e *entry)) {
+	for el := l.ll.Front(); el != nil; el = el.Next() {
+		fn(el.Value.(*entry))
+	}
+}
@@ -0,0 +1,386 @@
+package memcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aktau/gofinance/calendar"
+	"github.com/aktau/gofinance/fquery"
+)
+
+var (
+	VERBOSITY = 0
+)
+
+/* the defaults keep a few hundred symbols with about 10 years of daily
+ * history each, a few tens of MB */
+const (
+	MAX_ITEMS        = 1000
+	MAX_HIST_ENTRIES = 1000 * 1000
+)
+
+/* Cache keeps the answers of its source in memory, for long running
+ * processes that ask for the same symbols over and over. It's meant to be
+ * stacked in front of a persistent cache like the SqliteCache:
+ *
+ *     disk, _ := sqlitecache.New(path, src)
+ *     cache := memcache.New(disk)
+ *
+ * Quotes expire like they do in any other cache (see calendar.Expired),
+ * full histories and dividends are kept until the day they were fetched is
+ * over. Limited histories are cut out of a full one if it's there, and
+ * passed through to the source otherwise. Stale data (see
+ * fquery.Quote.Stale) is passed on but never kept. */
+type Cache struct {
+	fquery.Source
+
+	/* the same source as the embedded one, but cancellable */
+	src fquery.ContextSource
+
+	mu          sync.Mutex
+	lru         *lru
+	quoteExpiry time.Duration
+}
+
+/* Option configures a Cache, pass them to New */
+type Option func(c *Cache)
+
+/* WithMaxItems limits the amount of quotes, histories and dividend
+ * histories kept, together. 0 means no limit. */
+func WithMaxItems(n int) Option {
+	return func(c *Cache) {
+		c.lru.maxItems = n
+	}
+}
+
+/* WithMaxHistEntries limits the amount of days of history (and dividend
+ * payouts) kept, over all symbols. 0 means no limit. */
+func WithMaxHistEntries(n int) Option {
+	return func(c *Cache) {
+		c.lru.maxSize = n
+	}
+}
+
+func New(src fquery.Source, opts ...Option) *Cache {
+	c := &Cache{
+		Source:      src,
+		src:         fquery.WithContext(src),
+		lru:         newLru(MAX_ITEMS, MAX_HIST_ENTRIES),
+		quoteExpiry: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Cache) SetQuoteExpiry(dur time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quoteExpiry = dur
+}
+
+/* forgets everything and closes the source if it's something that can be
+ * closed, like another cache */
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	c.lru = newLru(c.lru.maxItems, c.lru.maxSize)
+	c.mu.Unlock()
+
+	if closer, ok := c.Source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *Cache) String() string {
+	return "memory cache, backed by: " + c.Source.String()
+}
+
+func (c *Cache) fresh(e *entry) bool {
+	if e.key.kind == kindQuote {
+		return !calendar.Expired(e.key.symbol, e.quote.Updated, c.quoteExpiry, time.Now())
+	}
+	return sameDay(e.fetched, time.Now())
+}
+
+/* looks up the fresh entries of symbols, found decides whether they can
+ * answer what was asked. Returns the symbols that have none that can. */
+func (c *Cache) lookup(k kind, symbols []string, found func(e *entry) bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var missing []string
+	for _, symbol := range symbols {
+		e, ok := c.lru.get(key{k, symbol})
+		if ok && c.fresh(e) && found(e) {
+			vprintln(symbol, "was fetched from memory!")
+		} else {
+			vprintln(symbol, "was NOT fetched from memory!")
+			missing = append(missing, symbol)
+		}
+	}
+	return missing
+}
+
+func (c *Cache) store(entries ...*entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range entries {
+		c.lru.add(e)
+	}
+}
+
+func (c *Cache) Quote(symbols []string) ([]fquery.Quote, error) {
+	return c.QuoteContext(context.Background(), symbols)
+}
+
+func (c *Cache) QuoteContext(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	results := make([]fquery.Quote, 0, len(symbols))
+	toFetch := c.lookup(kindQuote, symbols, func(e *entry) bool {
+		results = append(results, e.quote)
+		return true
+	})
+	if len(toFetch) == 0 {
+		return results, nil
+	}
+
+	fetched, err := c.src.QuoteContext(ctx, toFetch)
+	now := time.Now()
+	entries := make([]*entry, 0, len(fetched))
+	for _, q := range fetched {
+		if q.Stale == 0 {
+			entries = append(entries, &entry{key: key{kindQuote, q.Symbol}, fetched: now, quote: q})
+		}
+	}
+	c.store(entries...)
+
+	return append(results, fetched...), fquery.PerSymbol(err, toFetch).Err()
+}
+
+func (c *Cache) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	return c.HistContext(context.Background(), symbols)
+}
+
+func (c *Cache) HistContext(ctx context.Context, symbols []string) (map[string]fquery.Hist, error) {
+	results := make(map[string]fquery.Hist, len(symbols))
+	toFetch := c.lookup(kindHist, symbols, func(e *entry) bool {
+		results[e.key.symbol] = copyHist(e.hist)
+		return true
+	})
+	if len(toFetch) == 0 {
+		return results, nil
+	}
+
+	fetched, err := c.src.HistContext(ctx, toFetch)
+	now := time.Now()
+	entries := make([]*entry, 0, len(fetched))
+	for symbol, h := range fetched {
+		if h.Stale == 0 {
+			entries = append(entries, &entry{key: key{kindHist, symbol}, fetched: now, hist: copyHist(h)})
+		}
+		results[symbol] = h
+	}
+	c.store(entries...)
+
+	return results, fquery.PerSymbol(err, toFetch).Err()
+}
+
+func (c *Cache) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	return c.HistLimitContext(context.Background(), symbols, start, end)
+}
+
+/* only answered from memory when the full history is there, windows
+ * aren't kept by themselves. Neither are windows that start before it. */
+func (c *Cache) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	results := make(map[string]fquery.Hist, len(symbols))
+	toFetch := c.lookup(kindHist, symbols, func(e *entry) bool {
+		if !covers(e.hist, start) {
+			return false
+		}
+		results[e.key.symbol] = window(e.hist, start, end)
+		return true
+	})
+	if len(toFetch) == 0 {
+		return results, nil
+	}
+
+	fetched, err := c.src.HistLimitContext(ctx, toFetch, start, end)
+	for symbol, h := range fetched {
+		results[symbol] = h
+	}
+	return results, fquery.PerSymbol(err, toFetch).Err()
+}
+
+func (c *Cache) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
+	return c.DividendHistContext(context.Background(), symbols)
+}
+
+func (c *Cache) DividendHistContext(ctx context.Context, symbols []string) (map[string]fquery.DividendHist, error) {
+	results := make(map[string]fquery.DividendHist, len(symbols))
+	toFetch := c.lookup(kindDividends, symbols, func(e *entry) bool {
+		results[e.key.symbol] = copyDividends(e.divs)
+		return true
+	})
+	if len(toFetch) == 0 {
+		return results, nil
+	}
+
+	fetched, err := c.src.DividendHistContext(ctx, toFetch)
+	now := time.Now()
+	entries := make([]*entry, 0, len(fetched))
+	for symbol, d := range fetched {
+		entries = append(entries, &entry{key: key{kindDividends, symbol}, fetched: now, divs: copyDividends(d)})
+		results[symbol] = d
+	}
+	c.store(entries...)
+
+	return results, fquery.PerSymbol(err, toFetch).Err()
+}
+
+func (c *Cache) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return c.DividendHistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (c *Cache) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	results := make(map[string]fquery.DividendHist, len(symbols))
+	toFetch := c.lookup(kindDividends, symbols, func(e *entry) bool {
+		results[e.key.symbol] = windowDividends(e.divs, start, end)
+		return true
+	})
+	if len(toFetch) == 0 {
+		return results, nil
+	}
+
+	fetched, err := c.src.DividendHistLimitContext(ctx, toFetch, start, end)
+	for symbol, d := range fetched {
+		results[symbol] = d
+	}
+	return results, fquery.PerSymbol(err, toFetch).Err()
+}
+
+/* whether a quote for symbol can be answered without going to the source */
+func (c *Cache) HasQuote(symbol string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.lru.peek(key{kindQuote, symbol})
+	return ok && c.fresh(e)
+}
+
+/* the full history is all there is, so if it's there any window of it can
+ * be answered, as long as it doesn't start before the history does */
+func (c *Cache) HasHist(symbol string, start *time.Time, end *time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.lru.peek(key{kindHist, symbol})
+	return ok && c.fresh(e) && (start == nil || covers(e.hist, *start))
+}
+
+/* lists every symbol the cache holds something on, fresh or not */
+func (c *Cache) Contents() ([]fquery.CachedSymbol, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := make(map[string]*fquery.CachedSymbol)
+	var symbols []string
+	c.lru.each(func(e *entry) {
+		s, ok := m[e.key.symbol]
+		if !ok {
+			s = &fquery.CachedSymbol{Symbol: e.key.symbol}
+			m[e.key.symbol] = s
+			symbols = append(symbols, e.key.symbol)
+		}
+
+		switch e.key.kind {
+		case kindQuote:
+			s.QuoteUpdated = e.quote.Updated
+		case kindHist:
+			if len(e.hist.Entries) > 0 {
+				s.Hist = []fquery.DateRange{{From: dayOf(e.hist.From), To: dayOf(e.fetched)}}
+			}
+			s.HistEntries = len(e.hist.Entries)
+		case kindDividends:
+			s.Dividends = len(e.divs.Dividends)
+			s.DividendsUpdated = e.fetched
+		}
+	})
+
+	sort.Strings(symbols)
+	contents := make([]fquery.CachedSymbol, 0, len(symbols))
+	for _, symbol := range symbols {
+		contents = append(contents, *m[symbol])
+	}
+	return contents, nil
+}
+
+/* what's in the cache is never handed out directly, callers are free to
+ * modify what they get */
+func copyHist(hist fquery.Hist) fquery.Hist {
+	hist.Entries = append([]fquery.HistEntry(nil), hist.Entries...)
+	return hist
+}
+
+func copyDividends(divs fquery.DividendHist) fquery.DividendHist {
+	divs.Dividends = append([]fquery.DividendEntry(nil), divs.Dividends...)
+	return divs
+}
+
+/* the part of hist between start and end (both days included), without
+ * touching hist itself since it's shared */
+func window(hist fquery.Hist, start time.Time, end time.Time) fquery.Hist {
+	from, to := dayOf(start), dayOf(end)
+	w := fquery.Hist{Symbol: hist.Symbol}
+	for _, e := range hist.Entries {
+		if d := dayOf(e.Date.GetTime()); !d.Before(from) && !d.After(to) {
+			w.Entries = append(w.Entries, e)
+		}
+	}
+	if len(w.Entries) > 0 {
+		w.From = w.Entries[0].Date.GetTime()
+		w.To = w.Entries[len(w.Entries)-1].Date.GetTime()
+	}
+	return w
+}
+
+func windowDividends(divs fquery.DividendHist, start time.Time, end time.Time) fquery.DividendHist {
+	from, to := dayOf(start), dayOf(end)
+	w := fquery.DividendHist{Symbol: divs.Symbol}
+	for _, e := range divs.Dividends {
+		if d := dayOf(e.Date.GetTime()); !d.Before(from) && !d.After(to) {
+			w.Dividends = append(w.Dividends, e)
+		}
+	}
+	return w
+}
+
+/* whether the full history h has everything from start on: what the
+ * source has before its first day, it didn't hand out */
+func covers(h fquery.Hist, start time.Time) bool {
+	return !dayOf(start).Before(dayOf(h.From))
+}
+
+/* strips the time of day, keeping the date as it is in t's location */
+func dayOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sameDay(a, b time.Time) bool {
+	return dayOf(a).Equal(dayOf(b))
+}
+
+func vprintln(a ...interface{}) (int, error) {
+	if VERBOSITY > 0 {
+		return fmt.Fprintln(os.Stderr, a...)
+	}
+
+	return 0, nil
+}
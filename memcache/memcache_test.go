@@ -0,0 +1,221 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+var (
+	_ fquery.Cache       = &Cache{}
+	_ fquery.Inspectable = &Cache{}
+)
+
+/* a source that counts what it's asked for, it knows every symbol except
+ * the ones in fail */
+type source struct {
+	asked map[string][]string
+	fail  map[string]bool
+	stale bool
+}
+
+func newSource() *source {
+	return &source{asked: make(map[string][]string), fail: make(map[string]bool)}
+}
+
+func (s *source) known(action string, symbols []string) ([]string, error) {
+	s.asked[action] = append(s.asked[action], symbols...)
+
+	var ok []string
+	errs := make(fquery.SymbolErrors)
+	for _, symbol := range symbols {
+		if s.fail[symbol] {
+			errs.Add(symbol, fquery.KindNetwork, errors.New("offline"))
+		} else {
+			ok = append(ok, symbol)
+		}
+	}
+	return ok, errs.Err()
+}
+
+func (s *source) Quote(symbols []string) ([]fquery.Quote, error) {
+	ok, err := s.known("quote", symbols)
+	var quotes []fquery.Quote
+	for _, symbol := range ok {
+		q := fquery.Quote{Symbol: symbol, LastTradePrice: 10, Updated: time.Now()}
+		if s.stale {
+			q.Updated, q.Stale = time.Now().Add(-time.Hour), time.Hour
+		}
+		quotes = append(quotes, q)
+	}
+	return quotes, err
+}
+
+func (s *source) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	ok, err := s.known("hist", symbols)
+	hists := make(map[string]fquery.Hist)
+	for _, symbol := range ok {
+		h := fquery.Hist{Symbol: symbol}
+		for i := 30; i >= 0; i-- {
+			h.Entries = append(h.Entries, fquery.HistEntry{
+				Date:  util.YearMonthDay(dayOf(time.Now()).AddDate(0, 0, -i)),
+				Close: float64(i),
+			})
+		}
+		h.From, h.To = h.Entries[0].Date.GetTime(), h.Entries[30].Date.GetTime()
+		hists[symbol] = h
+	}
+	return hists, err
+}
+
+func (s *source) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	s.asked["histlimit"] = append(s.asked["histlimit"], symbols...)
+	return map[string]fquery.Hist{}, nil
+}
+
+func (s *source) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
+	return nil, fquery.ErrNotSupported(s, fquery.ActionDividendHist)
+}
+
+func (s *source) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return nil, fquery.ErrNotSupported(s, fquery.ActionDividendHistLimit)
+}
+
+func (s *source) Capabilities() fquery.Capabilities {
+	return fquery.Capabilities{}
+}
+
+func (s *source) String() string {
+	return "test"
+}
+
+func TestQuoteExpiry(t *testing.T) {
+	/* symbols of an exchange the calendar doesn't know, so that only the
+	 * expiry counts */
+	src := newSource()
+	c := New(src)
+
+	c.Quote([]string{"A.XX", "B.XX"})
+	quotes, err := c.Quote([]string{"A.XX", "B.XX", "C.XX"})
+	if err != nil || len(quotes) != 3 {
+		t.Fatalf("expected 3 quotes, got %v (%v)", len(quotes), err)
+	}
+	if got := src.asked["quote"]; len(got) != 3 {
+		t.Errorf("only C should have been asked for again, got %v", got)
+	}
+	if !c.HasQuote("A.XX") || c.HasQuote("D.XX") {
+		t.Errorf("wrong HasQuote")
+	}
+
+	c.SetQuoteExpiry(0)
+	c.Quote([]string{"A.XX"})
+	if got := src.asked["quote"]; len(got) != 4 {
+		t.Errorf("expired quote wasn't asked for again, got %v", got)
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	src := newSource()
+	src.fail["B"] = true
+	c := New(src)
+
+	quotes, err := c.Quote([]string{"A", "B"})
+	if len(quotes) != 1 || fquery.KindOf(err) != fquery.KindNetwork {
+		t.Fatalf("expected A and an error for B, got %v, %v", quotes, err)
+	}
+	if c.HasQuote("B") {
+		t.Errorf("a failed quote was cached")
+	}
+
+	src.stale = true
+	c.Quote([]string{"C"})
+	if c.HasQuote("C") {
+		t.Errorf("a stale quote was cached")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	src := newSource()
+	c := New(src, WithMaxItems(2))
+
+	c.Quote([]string{"A"})
+	c.Quote([]string{"B"})
+	c.Quote([]string{"A"}) /* A is now more recent than B */
+	c.Quote([]string{"C"})
+
+	if !c.HasQuote("A") || c.HasQuote("B") || !c.HasQuote("C") {
+		t.Errorf("expected B to be evicted")
+	}
+
+	/* 31 days of history take up more than 40 */
+	c = New(src, WithMaxHistEntries(40))
+	c.Hist([]string{"A"})
+	c.Hist([]string{"B"})
+	if c.HasHist("A", nil, nil) || !c.HasHist("B", nil, nil) {
+		t.Errorf("expected the history of A to be evicted")
+	}
+}
+
+func TestHist(t *testing.T) {
+	src := newSource()
+	c := New(src)
+
+	hists, err := c.Hist([]string{"A"})
+	if err != nil || len(hists["A"].Entries) != 31 {
+		t.Fatalf("wrong history: %v, %v", hists, err)
+	}
+
+	/* what's handed out can't change what's kept */
+	hists["A"].Entries[0].Close = -1
+
+	end := time.Now().AddDate(0, 0, -5)
+	start := end.AddDate(0, 0, -9)
+	hists, err = c.HistLimit([]string{"A"}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hists["A"]
+	if len(h.Entries) != 10 || h.Entries[0].Close != 14 || h.Entries[9].Close != 5 {
+		t.Errorf("wrong window: %+v", h.Entries)
+	}
+	if len(src.asked["hist"]) != 1 || len(src.asked["histlimit"]) != 0 {
+		t.Errorf("source asked too often: %v", src.asked)
+	}
+
+	hists, _ = c.Hist([]string{"A"})
+	if hists["A"].Entries[0].Close != 30 {
+		t.Errorf("cached history was modified")
+	}
+
+	c.HistLimit([]string{"B"}, start, end)
+	if len(src.asked["histlimit"]) != 1 {
+		t.Errorf("unknown windows should go to the source: %v", src.asked)
+	}
+
+	/* the history only goes back 30 days, the source may have more */
+	early := time.Now().AddDate(0, 0, -60)
+	if !c.HasHist("A", &start, &end) || c.HasHist("A", &early, &end) {
+		t.Errorf("wrong HasHist for windows starting in and before the history")
+	}
+	c.HistLimit([]string{"A"}, early, end)
+	if len(src.asked["histlimit"]) != 2 {
+		t.Errorf("a window starting before the history should go to the source: %v", src.asked)
+	}
+}
+
+func TestContents(t *testing.T) {
+	c := New(newSource())
+	c.Hist([]string{"B"})
+	c.Quote([]string{"A", "B"})
+
+	contents, err := c.Contents()
+	if err != nil || len(contents) != 2 {
+		t.Fatalf("expected 2 symbols, got %+v, %v", contents, err)
+	}
+	if contents[0].Symbol != "A" || contents[1].HistEntries != 31 || !contents[1].HasQuote() {
+		t.Errorf("wrong contents: %+v", contents)
+	}
+}
@@ -0,0 +1,312 @@
+package morningstar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+var VERBOSITY = 0
+
+const (
+	QUOTE_URL     = "http://quotes.morningstar.com/fund/c-header"
+	HIST_URL      = "http://performance.morningstar.com/perform/Performance/stock/exportStockPrice.action"
+	PORTFOLIO_URL = "http://portfolios.morningstar.com/fund/summary"
+)
+
+type Source struct {
+	client *http.Client
+
+	quoteURL     string
+	histURL      string
+	portfolioURL string
+}
+
+/* Option configures a Source, pass them to New */
+type Option func(s *Source)
+
+/* WithClient makes the source use client instead of http.DefaultClient */
+func WithClient(client *http.Client) Option {
+	return func(s *Source) {
+		s.client = client
+	}
+}
+
+/* WithQuoteURL, WithHistURL and WithPortfolioURL point the source
+ * somewhere else than Morningstar */
+func WithQuoteURL(url string) Option {
+	return func(s *Source) {
+		s.quoteURL = url
+	}
+}
+
+func WithHistURL(url string) Option {
+	return func(s *Source) {
+		s.histURL = url
+	}
+}
+
+func WithPortfolioURL(url string) Option {
+	return func(s *Source) {
+		s.portfolioURL = url
+	}
+}
+
+/* the returned source also implements fquery.FundSource */
+func New(opts ...Option) fquery.Source {
+	s := &Source{
+		client:       http.DefaultClient,
+		quoteURL:     QUOTE_URL,
+		histURL:      HIST_URL,
+		portfolioURL: PORTFOLIO_URL,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Source) Quote(symbols []string) ([]fquery.Quote, error) {
+	return s.QuoteContext(context.Background(), symbols)
+}
+
+func (s *Source) QuoteContext(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	slice := make([]fquery.Quote, 0, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		h, err := s.header(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		return h.quote(symbol), nil
+	}, func(symbol string, v interface{}) {
+		slice = append(slice, *v.(*fquery.Quote))
+	})
+	return slice, errs.Err()
+}
+
+func (s *Source) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	return s.HistContext(context.Background(), symbols)
+}
+
+func (s *Source) HistContext(ctx context.Context, symbols []string) (map[string]fquery.Hist, error) {
+	params := url.Values{}
+	params.Set("pd", "max")
+	return s.hist(ctx, symbols, params, time.Time{}, time.Time{})
+}
+
+func (s *Source) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	return s.HistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (s *Source) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	params := url.Values{}
+	params.Set("pd", "custom")
+	params.Set("sd", start.Format(FmtMonthDayYear))
+	params.Set("ed", end.Format(FmtMonthDayYear))
+	return s.hist(ctx, symbols, params, start, end)
+}
+
+func (s *Source) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
+	return s.DividendHistContext(context.Background(), symbols)
+}
+
+func (s *Source) DividendHistContext(ctx context.Context, symbols []string) (map[string]fquery.DividendHist, error) {
+	return nil, fquery.ErrNotSupported(s, fquery.ActionDividendHist)
+}
+
+func (s *Source) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return s.DividendHistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (s *Source) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return nil, fquery.ErrNotSupported(s, fquery.ActionDividendHistLimit)
+}
+
+func (s *Source) Fund(symbols []string) (map[string]fquery.FundInfo, error) {
+	return s.FundContext(context.Background(), symbols)
+}
+
+/* the fund information is spread over the quote header and the portfolio
+ * page, both are needed */
+func (s *Source) FundContext(ctx context.Context, symbols []string) (map[string]fquery.FundInfo, error) {
+	m := make(map[string]fquery.FundInfo, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		h, err := s.header(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		info := h.fund(symbol)
+
+		resp, err := s.get(ctx, s.portfolioURL, yahooToMorningstar(symbol), nil)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if err := parsePortfolio(resp.Body, info); err != nil {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindLayout, err)
+		}
+		return info, nil
+	}, func(symbol string, v interface{}) {
+		m[symbol] = *v.(*fquery.FundInfo)
+	})
+	return m, errs.Err()
+}
+
+func (s *Source) Capabilities() fquery.Capabilities {
+	return fquery.Capabilities{
+		Actions: []fquery.Action{
+			fquery.ActionQuote,
+			fquery.ActionHist,
+			fquery.ActionHistLimit,
+			fquery.ActionFund,
+		},
+		HistRanges: []fquery.HistRange{{Name: "max", Span: 0}},
+		Intervals:  []fquery.Interval{fquery.Daily},
+		QuoteFields: []string{
+			"Symbol", "Name", "Updated", "Volume",
+			"PreviousClose", "LastTradePrice", "DividendYield",
+			"Nav", "ExpenseRatio", "TotalAssets",
+		},
+	}
+}
+
+func (s *Source) String() string {
+	return "Morningstar"
+}
+
+func (s *Source) header(ctx context.Context, symbol string) (*msHeader, error) {
+	resp, err := s.get(ctx, s.quoteURL, yahooToMorningstar(symbol), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	h, err := parseHeader(resp.Body)
+	if err != nil {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindLayout, err)
+	}
+
+	/* morningstar answers unknown tickers with an empty header */
+	if h.Name == "" && h.float("NAV") == 0 {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+			fmt.Errorf("morningstar has no data for the symbol"))
+	}
+	return h, nil
+}
+
+/* start and end are only used to trim the result, if they're not zero */
+func (s *Source) hist(ctx context.Context, symbols []string, params url.Values, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	params.Set("freq", "d")
+	params.Set("culture", "en-US")
+
+	m := make(map[string]fquery.Hist, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		resp, err := s.get(ctx, s.histURL, yahooToMorningstar(symbol), params)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		hist, err := parseHist(resp.Body, symbol)
+		if err != nil {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindLayout, err)
+		}
+		if !start.IsZero() {
+			trim(hist, start, end)
+		}
+		if len(hist.Entries) == 0 {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+				fmt.Errorf("morningstar returned no history"))
+		}
+		return hist, nil
+	}, func(symbol string, v interface{}) {
+		m[symbol] = *v.(*fquery.Hist)
+	})
+	return m, errs.Err()
+}
+
+/* drops all entries outside of [start, end], whole days count */
+func trim(hist *fquery.Hist, start time.Time, end time.Time) {
+	entries := hist.Entries[:0]
+	for _, e := range hist.Entries {
+		if withinDays(e.Date.GetTime(), start, end) {
+			entries = append(entries, e)
+		}
+	}
+	hist.Entries = entries
+	if len(entries) > 0 {
+		hist.From = entries[0].Date.GetTime()
+		hist.To = entries[len(entries)-1].Date.GetTime()
+	}
+}
+
+/* whether the date of t falls in [start, end]. The entries are dates at
+ * midnight UTC, start and end may have a time of day in some other
+ * location, so only the dates are compared. */
+func withinDays(t, start, end time.Time) bool {
+	d := dateOf(t)
+	return !d.Before(dateOf(start)) && !d.After(dateOf(end))
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+/* requests base?t=ticker&params, aborts when ctx is done */
+func (s *Source) get(ctx context.Context, base string, ticker string, params url.Values) (*http.Response, error) {
+	q := url.Values{}
+	for key, vals := range params {
+		q[key] = vals
+	}
+	q.Set("t", ticker)
+	u := base + "?" + q.Encode()
+
+	vprintln("morningstar: fetching,", u)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching, url: %v, error: %w", u, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fquery.NewSymbolError(ticker, fquery.KindUnknownSymbol,
+			fmt.Errorf("url: %v, status: %v", u, resp.Status))
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, fquery.NewSymbolError(ticker, fquery.KindNetwork,
+			fmt.Errorf("url: %v, status: %v", u, resp.Status))
+	}
+	return resp, nil
+}
+
+/* fquery.Parallel, with the errors logged */
+func parallel(ctx context.Context, symbols []string,
+	fetch func(symbol string) (interface{}, error),
+	collect func(symbol string, v interface{})) fquery.SymbolErrors {
+
+	errs := fquery.Parallel(ctx, symbols, fetch, collect)
+	for _, symbol := range errs.Symbols() {
+		vprintln("morningstar: error while fetching,", errs[symbol])
+	}
+	return errs
+}
+
+func vprintln(a ...interface{}) (int, error) {
+	if VERBOSITY > 0 {
+		return fmt.Fprintln(os.Stderr, a...)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,208 @@
+package morningstar
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+func open(t *testing.T, name string) *os.File {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestParseHeader(t *testing.T) {
+	f := open(t, "c-header.html")
+	defer f.Close()
+
+	h, err := parseHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := h.quote("VEUR.AS")
+	if q.Name != "Vanguard FTSE Developed Europe UCITS ETF" {
+		t.Errorf("wrong name: %v", q.Name)
+	}
+	if !approx(q.LastTradePrice, 25.62) || !approx(q.PreviousClose, 25.45) {
+		t.Errorf("wrong prices: %v, %v", q.LastTradePrice, q.PreviousClose)
+	}
+	if q.Volume != 10432 {
+		t.Errorf("wrong volume: %v", q.Volume)
+	}
+	if !approx(q.DividendYield, 0.0284) {
+		t.Errorf("wrong dividend yield: %v", q.DividendYield)
+	}
+	if !q.IsFund() || !approx(q.ExpenseRatio, 0.0012) {
+		t.Errorf("expected fund fields, got nav %v, expense ratio %v", q.Nav, q.ExpenseRatio)
+	}
+
+	info := h.fund("VEUR.AS")
+	if info.Category != "Europe Large-Cap Blend Equity" {
+		t.Errorf("wrong category: %v", info.Category)
+	}
+	if info.Benchmark != "FTSE Developed Europe NR EUR" || info.CategoryBenchmark != "MSCI Europe NR EUR" {
+		t.Errorf("wrong benchmarks: %v, %v", info.Benchmark, info.CategoryBenchmark)
+	}
+	if !approx(info.Ter, 0.0012) {
+		t.Errorf("wrong TER: %v", info.Ter)
+	}
+	if !approx(h.float("TotalAssets"), 1204.5e6) {
+		t.Errorf("wrong total assets: %v", h.float("TotalAssets"))
+	}
+}
+
+func TestParsePortfolio(t *testing.T) {
+	f := open(t, "portfolio.html")
+	defer f.Close()
+
+	info := &fquery.FundInfo{}
+	if err := parsePortfolio(f, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(info.Sectors) != 3 || !approx(info.Sectors["Financial Services"], 0.2155) {
+		t.Errorf("wrong sectors: %v", info.Sectors)
+	}
+	if len(info.Regions) != 3 || !approx(info.Regions["United Kingdom"], 0.312) {
+		t.Errorf("wrong regions: %v", info.Regions)
+	}
+
+	if len(info.Holdings) != 3 {
+		t.Fatalf("expected 3 holdings, got %v", len(info.Holdings))
+	}
+	h := info.Holdings[0]
+	if h.Name != "Nestle SA" || h.Symbol != "NESN" || !approx(h.Weight, 0.0312) {
+		t.Errorf("wrong first holding: %+v", h)
+	}
+}
+
+func TestParseHist(t *testing.T) {
+	f := open(t, "prices.csv")
+	defer f.Close()
+
+	hist, err := parseHist(f, "VEUR.AS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hist.Entries) != 4 {
+		t.Fatalf("expected 4 entries, got %v", len(hist.Entries))
+	}
+	last := hist.Entries[3]
+	if !approx(last.Close, 25.62) || last.Volume != 10432 {
+		t.Errorf("wrong last entry: %+v", last)
+	}
+	if hist.From.Day() != 29 || hist.To.Month() != time.February {
+		t.Errorf("wrong range: %v - %v", hist.From, hist.To)
+	}
+}
+
+/* the source as a whole, against a stand-in that serves the fixtures */
+func TestTrim(t *testing.T) {
+	h := &fquery.Hist{Symbol: "VEUR.AS"}
+	for d := 1; d <= 3; d++ {
+		h.Entries = append(h.Entries, fquery.HistEntry{
+			Date:  util.YearMonthDay(time.Date(2014, time.January, d, 0, 0, 0, 0, time.UTC)),
+			Close: float64(d),
+		})
+	}
+
+	/* the afternoon of the second, two hours ahead of UTC: that day's
+	 * entry is from before then, but it's still the same day */
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	day2 := time.Date(2014, time.January, 2, 15, 0, 0, 0, loc)
+	trim(h, day2, day2)
+	if len(h.Entries) != 1 || h.Entries[0].Close != 2 {
+		t.Errorf("expected only the second of january, got %+v", h.Entries)
+	}
+	if !h.From.Equal(h.To) || h.From.Day() != 2 {
+		t.Errorf("wrong range: %v - %v", h.From, h.To)
+	}
+}
+
+func TestSource(t *testing.T) {
+	serve := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("t") {
+			case "XAMS:VEUR":
+				http.ServeFile(w, r, filepath.Join("testdata", name))
+			default:
+				http.ServeFile(w, r, filepath.Join("testdata", "c-header-unknown.html"))
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/c-header", serve("c-header.html"))
+	mux.HandleFunc("/portfolio", serve("portfolio.html"))
+	mux.HandleFunc("/prices", serve("prices.csv"))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	src := New(
+		WithClient(srv.Client()),
+		WithQuoteURL(srv.URL+"/c-header"),
+		WithPortfolioURL(srv.URL+"/portfolio"),
+		WithHistURL(srv.URL+"/prices"),
+	)
+
+	quotes, err := src.Quote([]string{"VEUR.AS", "NOPE.AS"})
+	if len(quotes) != 1 || quotes[0].Symbol != "VEUR.AS" {
+		t.Errorf("expected a quote for VEUR.AS, got %+v", quotes)
+	}
+	if fquery.KindOf(err) != fquery.KindUnknownSymbol {
+		t.Errorf("expected NOPE.AS to be unknown, got %v", err)
+	}
+
+	/* the capabilities cover everything that's filled in */
+	caps := src.Capabilities()
+	if len(quotes) == 1 {
+		q := reflect.ValueOf(quotes[0])
+		for i := 0; i < q.NumField(); i++ {
+			name := q.Type().Field(i).Name
+			if !q.Field(i).IsZero() && !caps.Fills(name) {
+				t.Errorf("the quote has %v, the capabilities don't say so", name)
+			}
+		}
+	}
+	for _, name := range []string{"Nav", "ExpenseRatio", "TotalAssets"} {
+		if !caps.Fills(name) {
+			t.Errorf("the capabilities leave out %v", name)
+		}
+	}
+
+	start := time.Date(2014, time.January, 30, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2014, time.January, 31, 0, 0, 0, 0, time.UTC)
+	hists, err := src.HistLimit([]string{"VEUR.AS"}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(hists["VEUR.AS"].Entries); n != 2 {
+		t.Errorf("expected 2 entries after trimming, got %v", n)
+	}
+
+	funds, err := src.(fquery.FundSource).Fund([]string{"VEUR.AS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := funds["VEUR.AS"]
+	if info.Benchmark == "" || len(info.Holdings) != 3 || len(info.Sectors) != 3 {
+		t.Errorf("incomplete fund info: %+v", info)
+	}
+}
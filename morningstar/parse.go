@@ -0,0 +1,317 @@
+package morningstar
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+	"golang.org/x/net/html"
+)
+
+/* the date format used in the price export */
+const FmtMonthDayYear = "01/02/2006"
+
+/* the header that sits on top of every morningstar quote page, the
+ * interesting values are all in <span vkey="..."> elements */
+type msHeader struct {
+	Name string
+	vals map[string]string
+}
+
+func (h *msHeader) str(key string) string {
+	return h.vals[key]
+}
+
+func (h *msHeader) float(key string) float64 {
+	return atof(h.vals[key])
+}
+
+/* for values given as percentages, returns a fraction */
+func (h *msHeader) perc(key string) float64 {
+	return atof(strings.TrimSuffix(h.vals[key], "%")) / 100
+}
+
+func parseHeader(r io.Reader) (*msHeader, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &msHeader{vals: make(map[string]string)}
+	walk(doc, func(n *html.Node) bool {
+		switch {
+		case n.Data == "div" && hasClass(n, "r_title"):
+			if h1 := findFirstChild(n, "h1"); h1 != nil {
+				h.Name = text(h1)
+			}
+			return false
+		case n.Data == "span" && attr(n, "vkey") != "":
+			h.vals[attr(n, "vkey")] = text(n)
+			return false
+		}
+		return true
+	})
+
+	if h.Name == "" && len(h.vals) == 0 {
+		return nil, fmt.Errorf("no quote header found")
+	}
+	return h, nil
+}
+
+func (h *msHeader) quote(symbol string) *fquery.Quote {
+	nav := h.float("NAV")
+	return &fquery.Quote{
+		Symbol:         symbol,
+		Name:           h.Name,
+		Updated:        time.Now(),
+		Volume:         int64(h.float("Volume")),
+		LastTradePrice: nav,
+		PreviousClose:  nav - h.float("DayChange"),
+		DividendYield:  h.perc("TTMYield"),
+		Nav:            nav,
+		ExpenseRatio:   h.perc("ExpenseRatio"),
+		TotalAssets:    h.float("TotalAssets"),
+	}
+}
+
+/* parses the portfolio page of a fund into info, which should already
+ * contain what's known from the header */
+func parsePortfolio(r io.Reader, info *fquery.FundInfo) error {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	info.Sectors = make(map[string]float64)
+	info.Regions = make(map[string]float64)
+
+	found := false
+	walk(doc, func(n *html.Node) bool {
+		if n.Data != "table" {
+			return true
+		}
+
+		switch attr(n, "id") {
+		/* the first column is the fund, the others are the benchmark and
+		 * the category average */
+		case "sector_we":
+			found = true
+			for _, row := range rows(n) {
+				info.Sectors[row.hdr] = row.perc(0)
+			}
+		case "world_regions_tab":
+			found = true
+			for _, row := range rows(n) {
+				info.Regions[row.hdr] = row.perc(0)
+			}
+		/* name, ticker, ..., weight */
+		case "holding_epage0":
+			found = true
+			for _, row := range rows(n) {
+				h := fquery.Holding{Name: row.hdr, Weight: row.perc(-1)}
+				if len(row.vals) > 1 {
+					h.Symbol = row.vals[0]
+				}
+				info.Holdings = append(info.Holdings, h)
+			}
+		}
+		return false
+	})
+
+	if !found {
+		return fmt.Errorf("no portfolio tables found")
+	}
+	return nil
+}
+
+func (h *msHeader) fund(symbol string) *fquery.FundInfo {
+	return &fquery.FundInfo{
+		Symbol:            symbol,
+		Name:              h.Name,
+		Category:          h.str("MorningstarCategory"),
+		Benchmark:         h.str("PrimaryIndex"),
+		CategoryBenchmark: h.str("CategoryIndex"),
+		Ter:               h.perc("ExpenseRatio"),
+	}
+}
+
+/* parses the price export, which is a line with the name of the
+ * security followed by a CSV file */
+func parseHist(r io.Reader, symbol string) (*fquery.Hist, error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if strings.HasPrefix(line, "Date,") {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("no price header found")
+		}
+	}
+
+	rd := csv.NewReader(br)
+	rd.FieldsPerRecord = 6
+
+	/* Date,Open,High,Low,Close,Volume */
+	entries := make([]fquery.HistEntry, 0, 365)
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := time.Parse(FmtMonthDayYear, rec[0])
+		if err != nil {
+			return nil, err
+		}
+
+		cl := atof(rec[4])
+		entries = append(entries, fquery.HistEntry{
+			Date:     util.YearMonthDay(t),
+			Open:     atof(rec[1]),
+			High:     atof(rec[2]),
+			Low:      atof(rec[3]),
+			Close:    cl,
+			AdjClose: cl,
+			Volume:   int64(atof(rec[5])),
+		})
+	}
+
+	hist := &fquery.Hist{Symbol: symbol, Entries: entries}
+	if len(entries) > 0 {
+		hist.From = entries[0].Date.GetTime()
+		hist.To = entries[len(entries)-1].Date.GetTime()
+	}
+	return hist, nil
+}
+
+type tableRow struct {
+	hdr  string
+	vals []string
+}
+
+/* reads column i (negative counts from the end) as a percentage and
+ * returns it as a fraction */
+func (r tableRow) perc(i int) float64 {
+	if i < 0 {
+		i += len(r.vals)
+	}
+	if i < 0 || i >= len(r.vals) {
+		return 0
+	}
+	return atof(r.vals[i]) / 100
+}
+
+/* returns all rows of a table that have a header */
+func rows(table *html.Node) []tableRow {
+	var rs []tableRow
+	walk(table, func(n *html.Node) bool {
+		if n.Data != "tr" {
+			return true
+		}
+
+		th := findFirstChild(n, "th")
+		if th == nil {
+			return false
+		}
+
+		r := tableRow{hdr: text(th)}
+		for c := th.NextSibling; c != nil; c = c.NextSibling {
+			if isTag(c, "td") {
+				r.vals = append(r.vals, text(c))
+			}
+		}
+		rs = append(rs, r)
+		return false
+	})
+	return rs
+}
+
+/* calls fn for every element node, depth first, fn returns false if it
+ * doesn't want to see the children of a node */
+func walk(n *html.Node, fn func(n *html.Node) bool) {
+	if n.Type == html.ElementNode && !fn(n) {
+		return
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+/* the trimmed text of all text nodes below n */
+func text(n *html.Node) string {
+	var b strings.Builder
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func isTag(n *html.Node, tag string) bool {
+	return n.Type == html.ElementNode && n.Data == tag
+}
+
+func findFirstChild(n *html.Node, tag string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isTag(c, tag) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+/* understands thousands separators and the Mil/Bil suffixes morningstar
+ * uses for amounts, returns 0 for anything else (e.g.: "—") */
+func atof(s string) float64 {
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, " Mil"):
+		mult, s = 1e6, strings.TrimSuffix(s, " Mil")
+	case strings.HasSuffix(s, " Bil"):
+		mult, s = 1e9, strings.TrimSuffix(s, " Bil")
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f * mult
+}
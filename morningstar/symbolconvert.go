@@ -0,0 +1,36 @@
+package morningstar
+
+import (
+	"strings"
+)
+
+/* morningstar prefixes tickers with the MIC code of the exchange */
+var yahooToMorningstarMap = map[string]string{
+	"AS": "XAMS", /* Amsterdam Euronext */
+	"BR": "XBRU", /* Brussels Euronext */
+	"PA": "XPAR", /* Paris Euronext */
+	"L":  "XLON", /* London Stock Exchange */
+	"MI": "XMIL", /* Milan */
+	"SI": "XSES", /* Singapore */
+	"DE": "XETR", /* Xetra Germany */
+	"F":  "XFRA", /* Frankfurt */
+	"SA": "BVMF", /* Sao Paulo, Brasil */
+	"MC": "XMAD", /* Madrid, Spain */
+	"MX": "XMEX", /* Mexico */
+}
+
+/* VEUR.AS -> XAMS:VEUR, symbols without an exchange are assumed to be
+ * US-based, morningstar doesn't need a prefix for those */
+func yahooToMorningstar(symbol string) string {
+	idx := strings.LastIndex(symbol, ".")
+	if idx < 0 {
+		return symbol
+	}
+
+	if mic, found := yahooToMorningstarMap[symbol[idx+1:]]; found {
+		return mic + ":" + symbol[:idx]
+	}
+
+	vprintln("morningstar: unknown symbol extension ", symbol)
+	return symbol
+}
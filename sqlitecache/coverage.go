@@ -0,0 +1,144 @@
+package sqlitecache
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/aktau/gofinance/calendar"
+)
+
+/* the cache remembers which days it has asked the source about, per
+ * symbol, so that it knows the difference between a day without trading
+ * (weekends, holidays) and a day it simply doesn't have. Days are stored
+ * as YYYY-MM-DD, ranges are inclusive on both ends. */
+
+const FmtDay = "2006-01-02"
+
+type interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (iv interval) empty() bool {
+	return iv.End.Before(iv.Start)
+}
+
+/* strips the time of day, keeping the date as it is in t's location */
+func dayOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func nextDay(t time.Time) time.Time {
+	return t.AddDate(0, 0, 1)
+}
+
+/* days that can still get (more) history never count as covered: the
+ * ones whose session hasn't closed yet */
+func lastCoverableDay(symbol string) time.Time {
+	return calendar.FinalDay(symbol, time.Now())
+}
+
+/* returns the covered ranges of every symbol that has any, in order */
+func (c *SqliteCache) coverage(symbols []string) (map[string][]interval, error) {
+	m := make(map[string][]interval, len(symbols))
+	for _, chunk := range chunks(symbols) {
+		if err := c.loadCoverage(m, chunk); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+/* adds the covered ranges of symbols to m, which fit in one query */
+func (c *SqliteCache) loadCoverage(m map[string][]interval, symbols []string) error {
+	rows, err := c.gorp.Db.Query(
+		`SELECT Symbol, FirstDay, LastDay FROM histcoverage
+		 WHERE Symbol IN (`+placeholders(len(symbols))+`)
+		 ORDER BY Symbol, FirstDay`,
+		args(symbols)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var symbol, start, end string
+		if err := rows.Scan(&symbol, &start, &end); err != nil {
+			return err
+		}
+
+		var iv interval
+		if iv.Start, err = time.Parse(FmtDay, start); err != nil {
+			return err
+		}
+		if iv.End, err = time.Parse(FmtDay, end); err != nil {
+			return err
+		}
+		m[symbol] = append(m[symbol], iv)
+	}
+
+	return rows.Err()
+}
+
+/* adds iv to the coverage of symbol, merging it with the ranges it
+ * overlaps or touches */
+func addCoverage(tx *sql.Tx, symbol string, iv interval) error {
+	if iv.empty() {
+		return nil
+	}
+
+	var start, end sql.NullString
+	err := tx.QueryRow(
+		`SELECT min(FirstDay), max(LastDay) FROM histcoverage
+		 WHERE Symbol = ? AND FirstDay <= ? AND LastDay >= ?`,
+		symbol,
+		nextDay(iv.End).Format(FmtDay),
+		iv.Start.AddDate(0, 0, -1).Format(FmtDay)).Scan(&start, &end)
+	if err != nil {
+		return err
+	}
+
+	merged := iv
+	if start.Valid && start.String < merged.Start.Format(FmtDay) {
+		merged.Start, _ = time.Parse(FmtDay, start.String)
+	}
+	if end.Valid && end.String > merged.End.Format(FmtDay) {
+		merged.End, _ = time.Parse(FmtDay, end.String)
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM histcoverage WHERE Symbol = ? AND FirstDay >= ? AND LastDay <= ?`,
+		symbol, merged.Start.Format(FmtDay), merged.End.Format(FmtDay))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO histcoverage (Symbol, FirstDay, LastDay) VALUES (?, ?, ?)`,
+		symbol, merged.Start.Format(FmtDay), merged.End.Format(FmtDay))
+	return err
+}
+
+/* returns the parts of want that aren't in covered, which must be sorted */
+func missing(covered []interval, want interval) []interval {
+	var gaps []interval
+	cur := want.Start
+	for _, iv := range covered {
+		if iv.End.Before(cur) {
+			continue
+		}
+		if iv.Start.After(want.End) {
+			break
+		}
+
+		if gap := (interval{cur, iv.Start.AddDate(0, 0, -1)}); !gap.empty() {
+			gaps = append(gaps, gap)
+		}
+		cur = nextDay(iv.End)
+	}
+
+	if gap := (interval{cur, want.End}); !gap.empty() {
+		gaps = append(gaps, gap)
+	}
+	return gaps
+}
@@ -0,0 +1,89 @@
+package sqlitecache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+/* the ith of january 2014 */
+func jan(i int) time.Time {
+	return time.Date(2014, time.January, i, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMissing(t *testing.T) {
+	cases := []struct {
+		name    string
+		covered []interval
+		want    interval
+		gaps    []interval
+	}{
+		{"nothing covered", nil, interval{jan(1), jan(5)},
+			[]interval{{jan(1), jan(5)}}},
+		{"all covered", []interval{{jan(1), jan(10)}}, interval{jan(2), jan(5)},
+			nil},
+		{"a gap in between", []interval{{jan(1), jan(3)}, {jan(7), jan(10)}}, interval{jan(1), jan(10)},
+			[]interval{{jan(4), jan(6)}}},
+		{"adjacent", []interval{{jan(1), jan(3)}, {jan(4), jan(6)}}, interval{jan(1), jan(6)},
+			nil},
+		{"overlapping", []interval{{jan(1), jan(5)}, {jan(3), jan(8)}}, interval{jan(2), jan(10)},
+			[]interval{{jan(9), jan(10)}}},
+		{"head and tail", []interval{{jan(3), jan(5)}}, interval{jan(1), jan(8)},
+			[]interval{{jan(1), jan(2)}, {jan(6), jan(8)}}},
+		{"past what's covered", []interval{{jan(1), jan(3)}}, interval{jan(5), jan(8)},
+			[]interval{{jan(5), jan(8)}}},
+		{"before what's covered", []interval{{jan(5), jan(8)}}, interval{jan(1), jan(3)},
+			[]interval{{jan(1), jan(3)}}},
+		{"a single day", []interval{{jan(1), jan(1)}, {jan(3), jan(3)}}, interval{jan(1), jan(3)},
+			[]interval{{jan(2), jan(2)}}},
+	}
+	for _, c := range cases {
+		if gaps := missing(c.covered, c.want); !reflect.DeepEqual(gaps, c.gaps) {
+			t.Errorf("%v: got %v, want %v", c.name, gaps, c.gaps)
+		}
+	}
+}
+
+func TestHistLimitTail(t *testing.T) {
+	src := newSource(0)
+	c := newCache(t, src)
+
+	/* days ago, the symbol has no known exchange so every day counts */
+	today := dayOf(time.Now())
+	ago := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+
+	hists, err := c.HistLimit([]string{"A.XX"}, ago(50), ago(40))
+	if err != nil || len(hists["A.XX"].Entries) != 11 {
+		t.Fatalf("got %v entries, %v", len(hists["A.XX"].Entries), err)
+	}
+
+	/* only the days after what the cache has are fetched */
+	src.limits = nil
+	hists, err = c.HistLimit([]string{"A.XX"}, ago(50), ago(30))
+	if err != nil || len(hists["A.XX"].Entries) != 21 {
+		t.Fatalf("got %v entries, %v", len(hists["A.XX"].Entries), err)
+	}
+	if want := []interval{{ago(39), ago(30)}}; !reflect.DeepEqual(src.limits, want) {
+		t.Errorf("fetched %v, want %v", src.limits, want)
+	}
+
+	/* a window up to today: today can't be covered, but as the rest of
+	 * the window has to be fetched anyway it's asked for as well */
+	src.limits = nil
+	hists, err = c.HistLimit([]string{"A.XX"}, ago(60), today)
+	if err != nil || len(hists["A.XX"].Entries) != 61 {
+		t.Fatalf("got %v entries, %v", len(hists["A.XX"].Entries), err)
+	}
+	sort.Slice(src.limits, func(i, j int) bool { return src.limits[i].Start.Before(src.limits[j].Start) })
+	if want := []interval{{ago(60), ago(51)}, {ago(29), today}}; !reflect.DeepEqual(src.limits, want) {
+		t.Errorf("fetched %v, want %v", src.limits, want)
+	}
+
+	/* everything up to the last day that can be covered is now known */
+	src.limits = nil
+	end := minTime(today, lastCoverableDay("A.XX"))
+	if _, err := c.HistLimit([]string{"A.XX"}, ago(60), end); err != nil || len(src.limits) != 0 {
+		t.Errorf("fetched %v again, %v", src.limits, err)
+	}
+}
@@ -0,0 +1,217 @@
+package sqlitecache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+/* companies pay out a handful of times a year at most, so there's no use
+ * in asking for the dividends of a symbol more often than this */
+const DIVIDEND_EXPIRY = 7 * 24 * time.Hour
+
+type dbDividendEntry struct {
+	Symbol    string
+	Date      time.Time
+	Dividends float64
+}
+
+func (c *SqliteCache) SetDividendExpiry(dur time.Duration) {
+	c.dividendExpiry = dur
+}
+
+func (c *SqliteCache) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
+	return c.DividendHistContext(context.Background(), symbols)
+}
+
+func (c *SqliteCache) DividendHistContext(ctx context.Context, symbols []string) (map[string]fquery.DividendHist, error) {
+	errs, err := c.refreshDividends(ctx, symbols)
+	if err != nil {
+		vprintln("sqlitecache: error while checking dividends, ", err, ", will use underlying source")
+		divs, err := c.src.DividendHistContext(ctx, symbols)
+		return divs, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	divs, err := c.loadDividends(symbols, interval{})
+	if err != nil {
+		vprintln("sqlitecache: error while fetching dividends, ", err, ", will use underlying source")
+		divs, err := c.src.DividendHistContext(ctx, symbols)
+		return divs, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	for symbol := range errs {
+		delete(divs, symbol)
+	}
+	return divs, errs.Err()
+}
+
+func (c *SqliteCache) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return c.DividendHistLimitContext(context.Background(), symbols, start, end)
+}
+
+/* the full history is so small that it's cached as a whole, the window
+ * is taken out of that */
+func (c *SqliteCache) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	if !c.src.Capabilities().Supports(fquery.ActionDividendHist) {
+		divs, err := c.src.DividendHistLimitContext(ctx, symbols, start, end)
+		return divs, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	errs, err := c.refreshDividends(ctx, symbols)
+	if err != nil {
+		vprintln("sqlitecache: error while checking dividends, ", err, ", will use underlying source")
+		divs, err := c.src.DividendHistLimitContext(ctx, symbols, start, end)
+		return divs, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	divs, err := c.loadDividends(symbols, interval{dayOf(start), dayOf(end)})
+	if err != nil {
+		vprintln("sqlitecache: error while fetching dividends, ", err, ", will use underlying source")
+		divs, err := c.src.DividendHistLimitContext(ctx, symbols, start, end)
+		return divs, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	for symbol := range errs {
+		delete(divs, symbol)
+	}
+	return divs, errs.Err()
+}
+
+/* fetches the dividends of the symbols that have expired and merges them
+ * into the cache, the returned error is about the cache itself, the
+ * SymbolErrors about the source */
+func (c *SqliteCache) refreshDividends(ctx context.Context, symbols []string) (fquery.SymbolErrors, error) {
+	cutoff := time.Now().Add(-c.dividendExpiry)
+
+	var fetched []string
+	for _, chunk := range chunks(symbols) {
+		_, err := c.gorp.Select(&fetched,
+			`SELECT Symbol FROM dividendsfetched
+			 WHERE Symbol IN (`+placeholders(len(chunk))+`) AND Fetched >= ?`,
+			args(chunk, cutoff.Unix())...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fresh := make(map[string]bool, len(fetched))
+	for _, symbol := range fetched {
+		fresh[symbol] = true
+	}
+
+	stale := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		if fresh[symbol] {
+			vprintln(symbol, "dividends were fetched from cache!")
+		} else {
+			vprintln(symbol, "dividends were NOT fetched from cache!")
+			stale = append(stale, symbol)
+		}
+	}
+
+	errs, _ := c.flights.do(ctx, fquery.ActionDividendHist, stale, func(mine []string) fquery.SymbolErrors {
+		divs, err := c.src.DividendHistContext(ctx, mine)
+		if err != nil {
+			vprintln("sqlitecache: error occured while fetching", mine, "dividends,", err)
+		}
+
+		if err := c.storeDividends(divs); err != nil {
+			vprintln("sqlitecache: error, could not merge dividends into cache,", err)
+		}
+		return fquery.PerSymbol(err, mine)
+	}, nil)
+	return errs, nil
+}
+
+/* adds the entries of divs to the cache, payouts don't change after the
+ * fact so nothing is deleted */
+func (c *SqliteCache) storeDividends(divs map[string]fquery.DividendHist) error {
+	if len(divs) == 0 {
+		return nil
+	}
+
+	tx, err := c.gorp.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT OR REPLACE INTO dividends (Symbol, Date, Dividends) VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer insert.Close()
+
+	fetched, err := tx.Prepare(`INSERT OR REPLACE INTO dividendsfetched (Symbol, Fetched) VALUES (?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer fetched.Close()
+
+	now := time.Now().Unix()
+	for symbol, d := range divs {
+		vprintln("merging dividends:", symbol)
+		for _, e := range d.Dividends {
+			if _, err := insert.Exec(symbol, time.Time(e.Date), e.Dividends); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("could not insert dividends of %v: %w", symbol, err)
+			}
+		}
+		if _, err := fetched.Exec(symbol, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/* reads the dividends of symbols from the cache, limited to the days in
+ * iv unless it's the zero interval. Symbols the cache knows about but that
+ * never paid out get an empty history. */
+func (c *SqliteCache) loadDividends(symbols []string, iv interval) (map[string]fquery.DividendHist, error) {
+	var extra []interface{}
+	cond := ``
+	if !iv.Start.IsZero() {
+		cond = ` AND substr(Date, 1, 10) BETWEEN ? AND ?`
+		extra = append(extra, iv.Start.Format(FmtDay), iv.End.Format(FmtDay))
+	}
+
+	var (
+		results []dbDividendEntry
+		known   []string
+	)
+	for _, chunk := range chunks(symbols) {
+		query := `SELECT * FROM dividends WHERE Symbol IN (` + placeholders(len(chunk)) + `)` +
+			cond + ` ORDER BY Symbol, Date`
+		if _, err := c.gorp.Select(&results, query, args(chunk, extra...)...); err != nil {
+			return nil, err
+		}
+
+		_, err := c.gorp.Select(&known,
+			`SELECT Symbol FROM dividendsfetched WHERE Symbol IN (`+placeholders(len(chunk))+`)`,
+			args(chunk)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	divs := make(map[string]fquery.DividendHist, len(symbols))
+	for _, symbol := range known {
+		divs[symbol] = fquery.DividendHist{Symbol: symbol}
+	}
+	for _, e := range results {
+		d := divs[e.Symbol]
+		d.Symbol = e.Symbol
+		d.Dividends = append(d.Dividends, fquery.DividendEntry{
+			Date:      util.YearMonthDay(e.Date),
+			Dividends: e.Dividends,
+		})
+		divs[e.Symbol] = d
+	}
+
+	return divs, nil
+}
@@ -0,0 +1,91 @@
+package sqlitecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+func TestDividendExpiry(t *testing.T) {
+	today := dayOf(time.Now())
+	paid := func(days int, amount float64) fquery.DividendEntry {
+		return fquery.DividendEntry{Date: util.YearMonthDay(today.AddDate(0, 0, -days)), Dividends: amount}
+	}
+
+	/* NONE.XX never paid out */
+	src := newSource(1)
+	src.dividends = map[string][]fquery.DividendEntry{"A.XX": {paid(200, 0.5)}, "NONE.XX": nil}
+	c := newCache(t, src)
+
+	get := func(fetched int) map[string]fquery.DividendHist {
+		t.Helper()
+		divs, err := c.DividendHist([]string{"A.XX", "NONE.XX"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := src.calls("dividends"); n != fetched {
+			t.Fatalf("asked the source for %v symbols, want %v", n, fetched)
+		}
+		if d, ok := divs["NONE.XX"]; !ok || len(d.Dividends) != 0 {
+			t.Errorf("got %+v for a symbol that never paid out", divs["NONE.XX"])
+		}
+		return divs
+	}
+	/* makes the dividends in the cache older than they are */
+	age := func(d time.Duration) {
+		t.Helper()
+		if _, err := c.gorp.Exec(`UPDATE dividendsfetched SET Fetched = Fetched - ?`, int64(d.Seconds())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get(2)
+	get(2)
+
+	/* not yet */
+	age(DIVIDEND_EXPIRY - time.Hour)
+	get(2)
+
+	/* the source only has the latest payout now, the old one stays */
+	src.mu.Lock()
+	src.dividends["A.XX"] = []fquery.DividendEntry{paid(10, 0.6)}
+	src.mu.Unlock()
+	age(2 * time.Hour)
+	divs := get(4)
+	if d := divs["A.XX"].Dividends; len(d) != 2 || d[0].Dividends != 0.5 || d[1].Dividends != 0.6 {
+		t.Errorf("the payouts weren't merged: %+v", d)
+	}
+
+	c.SetDividendExpiry(time.Minute)
+	get(4)
+	age(2 * time.Minute)
+	get(6)
+}
+
+func TestDividendHistLimit(t *testing.T) {
+	src := newSource(1)
+	c := newCache(t, src)
+
+	/* the window comes out of the full history, whatever the time of day */
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		divs, err := c.DividendHistLimit([]string{"A.XX"}, now.AddDate(0, -2, 0), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := divs["A.XX"].Dividends; len(d) != 1 || d[0].Dividends != 0.6 {
+			t.Errorf("got %+v, want the payout of a month ago", d)
+		}
+	}
+	if src.calls("dividends") != 1 || src.calls("dividendslimit") != 0 {
+		t.Errorf("asked the source %v", src.asked)
+	}
+
+	/* and the full history is there too */
+	divs, err := c.DividendHist([]string{"A.XX"})
+	if err != nil || len(divs["A.XX"].Dividends) != 2 || src.calls("dividends") != 1 {
+		t.Errorf("got %+v, %v, asked %v", divs, err, src.asked)
+	}
+}
@@ -0,0 +1,190 @@
+package sqlitecache
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+/* keeps track of the fetches that are underway, so that callers that
+ * miss the same symbol at the same time share one fetch from the source
+ * and one write to the cache. The first one to miss a symbol fetches it,
+ * the others wait for it to land and then read it from the cache. */
+type flights struct {
+	mu sync.Mutex
+	m  map[flightKey]*flight
+}
+
+/* all history of a symbol shares one flight, whatever the window: what
+ * one caller fetches may well be what the other needs, and they'd write
+ * to the same rows */
+type flightKey struct {
+	action fquery.Action
+	symbol string
+}
+
+type flight struct {
+	done chan struct{}
+	err  *fquery.SymbolError /* set before done is closed */
+}
+
+var errAborted = errors.New("the fetch was aborted")
+
+/* splits symbols in the ones the caller has to fetch itself, and the ones
+ * that are already being fetched by someone else. The caller has to land
+ * every symbol it gets back in mine, whatever happens. */
+func (f *flights) claim(action fquery.Action, symbols []string) (mine []string, theirs map[string]*flight) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.m == nil {
+		f.m = make(map[flightKey]*flight)
+	}
+
+	theirs = make(map[string]*flight)
+	for _, symbol := range symbols {
+		k := flightKey{action, symbol}
+		if fl, ok := f.m[k]; ok {
+			vprintln(symbol, "is already being fetched, waiting for it")
+			theirs[symbol] = fl
+			continue
+		}
+		f.m[k] = &flight{done: make(chan struct{})}
+		mine = append(mine, symbol)
+	}
+	return mine, theirs
+}
+
+/* tells the callers waiting for symbols that they're in the cache, or why
+ * not */
+func (f *flights) land(action fquery.Action, symbols []string, errs fquery.SymbolErrors) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, symbol := range symbols {
+		k := flightKey{action, symbol}
+		fl := f.m[k]
+		delete(f.m, k)
+		fl.err = errs[symbol]
+		close(fl.done)
+	}
+}
+
+/* waits for the fetches of others to land, returns the symbols that
+ * failed to, and why */
+func (f *flights) wait(ctx context.Context, theirs map[string]*flight) fquery.SymbolErrors {
+	errs := make(fquery.SymbolErrors)
+	for symbol, fl := range theirs {
+		select {
+		case <-fl.done:
+			if fl.err != nil {
+				errs[symbol] = fl.err
+			}
+		case <-ctx.Done():
+			errs.Add(symbol, fquery.KindCanceled, ctx.Err())
+		}
+	}
+	return errs
+}
+
+/* fetches the symbols that were claimed and lands them, also when fetch
+ * doesn't return, so no one waits forever */
+func (f *flights) fetch(action fquery.Action, mine []string, fetch func(mine []string) fquery.SymbolErrors) (errs fquery.SymbolErrors) {
+	done := false
+	defer func() {
+		if !done {
+			errs = make(fquery.SymbolErrors)
+			for _, symbol := range mine {
+				errs.Add(symbol, fquery.KindOther, errAborted)
+			}
+		}
+		f.land(action, mine, errs)
+	}()
+
+	errs = fetch(mine)
+	done = true
+	return errs
+}
+
+/* the symbols whose flight failed in a way that may have been down to the
+ * caller that was fetching them (it was cancelled, or gave up) rather than
+ * to the symbol itself */
+func retryable(errs fquery.SymbolErrors) []string {
+	var symbols []string
+	for symbol, serr := range errs {
+		if serr.Kind != fquery.KindUnknownSymbol && serr.Kind != fquery.KindNotSupported {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+/* claims symbols, fetches the ones no one else was fetching yet and waits
+ * for the others. fetch returns the errors of the symbols it's given.
+ *
+ * The flight of someone else is no guarantee: the caller behind it may
+ * have been cancelled, or have fetched less than was needed here. So the
+ * symbols of failed flights get another round, as do the ones that landed
+ * but for which again (if not nil) says there's still something missing.
+ * After that, what the others fetched has to do.
+ *
+ * Returns the errors of all symbols, and the symbols others fetched. */
+func (f *flights) do(ctx context.Context, action fquery.Action, symbols []string,
+	fetch func(mine []string) fquery.SymbolErrors,
+	again func(symbols []string) []string) (errs fquery.SymbolErrors, landed []string) {
+
+	errs = make(fquery.SymbolErrors)
+	for round := 1; round <= 2 && len(symbols) > 0; round++ {
+		mine, theirs := f.claim(action, symbols)
+		if len(mine) > 0 {
+			errs.Merge(f.fetch(action, mine, fetch))
+		}
+		if len(theirs) == 0 {
+			break
+		}
+
+		failed := f.wait(ctx, theirs)
+		var ok []string
+		for symbol := range theirs {
+			if _, bad := failed[symbol]; !bad {
+				ok = append(ok, symbol)
+			}
+		}
+
+		symbols = nil
+		if round == 1 && ctx.Err() == nil {
+			symbols = retryable(failed)
+			if again != nil {
+				symbols = append(symbols, again(ok)...)
+			}
+			for _, symbol := range symbols {
+				delete(failed, symbol)
+			}
+		}
+		if len(symbols) > 0 {
+			vprintln("sqlitecache: others didn't fetch", symbols, "(fully), trying again")
+		}
+
+		errs.Merge(failed)
+		landed = append(landed, without(ok, symbols)...)
+	}
+	return errs, landed
+}
+
+/* xs without the ones in ys */
+func without(xs, ys []string) []string {
+	skip := make(map[string]bool, len(ys))
+	for _, y := range ys {
+		skip[y] = true
+	}
+
+	var res []string
+	for _, x := range xs {
+		if !skip[x] {
+			res = append(res, x)
+		}
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package sqlitecache
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+/* whether a quote for symbol can be answered without going to the source */
+func (c *SqliteCache) HasQuote(symbol string) bool {
+	quotes, err := c.loadQuotes([]string{symbol})
+	if err != nil {
+		vprintln("sqlitecache: error while checking for quote of", symbol, err)
+		return false
+	}
+	return len(quotes) > 0 && !c.expired(&quotes[0])
+}
+
+/* whether the history of symbol between start and end can be answered
+ * without going to the source. A nil start means since the first day that
+ * is cached, a nil end means up to the most recent one that can be. */
+func (c *SqliteCache) HasHist(symbol string, start *time.Time, end *time.Time) bool {
+	cov, err := c.coverage([]string{symbol})
+	if err != nil {
+		vprintln("sqlitecache: error while checking for history of", symbol, err)
+		return false
+	}
+
+	ivs := cov[symbol]
+	if len(ivs) == 0 {
+		return false
+	}
+
+	want := interval{ivs[0].Start, lastCoverableDay(symbol)}
+	if start != nil {
+		want.Start = dayOf(*start)
+	}
+	if end != nil {
+		want.End = minTime(dayOf(*end), want.End)
+	}
+	return len(missing(ivs, want)) == 0
+}
+
+/* lists every symbol the cache knows something about */
+func (c *SqliteCache) Contents() ([]fquery.CachedSymbol, error) {
+	m := make(map[string]*fquery.CachedSymbol)
+	get := func(symbol string) *fquery.CachedSymbol {
+		s, ok := m[symbol]
+		if !ok {
+			s = &fquery.CachedSymbol{Symbol: symbol}
+			m[symbol] = s
+		}
+		return s
+	}
+
+	var quotes []struct {
+		Symbol  string
+		Updated time.Time
+	}
+	if _, err := c.gorp.Select(&quotes, `SELECT Symbol, Updated FROM quotes`); err != nil {
+		return nil, err
+	}
+	for _, q := range quotes {
+		get(q.Symbol).QuoteUpdated = q.Updated
+	}
+
+	var counts []struct {
+		Symbol string
+		Amount int
+	}
+	if _, err := c.gorp.Select(&counts,
+		`SELECT Symbol, count(*) AS Amount FROM histquotes GROUP BY Symbol`); err != nil {
+		return nil, err
+	}
+	for _, n := range counts {
+		get(n.Symbol).HistEntries = n.Amount
+	}
+
+	counts = nil
+	if _, err := c.gorp.Select(&counts,
+		`SELECT Symbol, count(*) AS Amount FROM dividends GROUP BY Symbol`); err != nil {
+		return nil, err
+	}
+	for _, n := range counts {
+		get(n.Symbol).Dividends = n.Amount
+	}
+
+	var fetched []struct {
+		Symbol  string
+		Fetched int64
+	}
+	if _, err := c.gorp.Select(&fetched, `SELECT Symbol, Fetched FROM dividendsfetched`); err != nil {
+		return nil, err
+	}
+	for _, f := range fetched {
+		get(f.Symbol).DividendsUpdated = time.Unix(f.Fetched, 0)
+	}
+
+	var coverage []struct {
+		Symbol   string
+		FirstDay string
+		LastDay  string
+	}
+	if _, err := c.gorp.Select(&coverage,
+		`SELECT Symbol, FirstDay, LastDay FROM histcoverage ORDER BY Symbol, FirstDay`); err != nil {
+		return nil, err
+	}
+	for _, cov := range coverage {
+		from, err := time.Parse(FmtDay, cov.FirstDay)
+		if err != nil {
+			return nil, err
+		}
+		to, err := time.Parse(FmtDay, cov.LastDay)
+		if err != nil {
+			return nil, err
+		}
+		s := get(cov.Symbol)
+		s.Hist = append(s.Hist, fquery.DateRange{From: from, To: to})
+	}
+
+	symbols := make([]fquery.CachedSymbol, 0, len(m))
+	for _, s := range m {
+		symbols = append(symbols, *s)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Symbol < symbols[j].Symbol
+	})
+	return symbols, nil
+}
@@ -0,0 +1,138 @@
+package sqlitecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+func TestHasQuote(t *testing.T) {
+	c := newCache(t, newSource(1))
+	c.SetQuoteExpiry(time.Hour)
+
+	if c.HasQuote("A.XX") {
+		t.Error("there's a quote before anything was fetched")
+	}
+	if _, err := c.Quote([]string{"A.XX"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasQuote("A.XX") {
+		t.Error("a fresh quote isn't there")
+	}
+
+	/* the symbol has no known exchange, so the expiry is all that counts */
+	old := fquery.Quote{Symbol: "A.XX", Updated: time.Now().Add(-2 * time.Hour), LastTradePrice: 1}
+	if err := c.mergeQuotes(old); err != nil {
+		t.Fatal(err)
+	}
+	if c.HasQuote("A.XX") {
+		t.Error("an expired quote counts")
+	}
+}
+
+func TestHasHist(t *testing.T) {
+	src := newSource(60)
+	c := newCache(t, src)
+
+	today := dayOf(time.Now())
+	ago := func(n int) *time.Time {
+		t := today.AddDate(0, 0, -n)
+		return &t
+	}
+
+	if c.HasHist("A.XX", nil, nil) {
+		t.Error("there's history before anything was fetched")
+	}
+
+	/* two windows, with a gap in between */
+	for _, iv := range [][2]int{{50, 40}, {30, 20}} {
+		if _, err := c.HistLimit([]string{"A.XX"}, *ago(iv[0]), *ago(iv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name       string
+		start, end *time.Time
+		has        bool
+	}{
+		{"all of it", nil, nil, false},
+		{"in the first window", ago(48), ago(42), true},
+		{"in the second window", ago(30), ago(20), true},
+		{"across the gap", ago(45), ago(25), false},
+		{"in the gap", ago(35), ago(32), false},
+		{"before the first window", ago(55), ago(45), false},
+		{"from the start", nil, ago(45), true},
+		{"up to the last day", ago(25), nil, false},
+	}
+	for _, cs := range cases {
+		if has := c.HasHist("A.XX", cs.start, cs.end); has != cs.has {
+			t.Errorf("%v: got %v, want %v", cs.name, has, cs.has)
+		}
+	}
+
+	/* once it's all there */
+	if _, err := c.HistLimit([]string{"A.XX"}, *ago(60), today); err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasHist("A.XX", nil, nil) || !c.HasHist("A.XX", ago(45), ago(25)) || !c.HasHist("A.XX", ago(25), nil) {
+		t.Error("the full history doesn't count")
+	}
+	if c.HasHist("B.XX", nil, nil) {
+		t.Error("history of a symbol that was never fetched")
+	}
+}
+
+func TestContents(t *testing.T) {
+	src := newSource(1)
+	src.dividends = map[string][]fquery.DividendEntry{"B.XX": nil}
+	c := newCache(t, src)
+
+	today := dayOf(time.Now())
+	ago := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+	if _, err := c.Quote([]string{"A.XX"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, iv := range [][2]int{{50, 40}, {30, 20}} {
+		if _, err := c.HistLimit([]string{"A.XX"}, ago(iv[0]), ago(iv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.mu.Lock()
+	src.dividends["A.XX"] = []fquery.DividendEntry{
+		{Date: util.YearMonthDay(ago(100)), Dividends: 0.5},
+		{Date: util.YearMonthDay(ago(10)), Dividends: 0.6},
+	}
+	src.mu.Unlock()
+	if _, err := c.DividendHist([]string{"A.XX", "B.XX"}); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := c.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 2 || contents[0].Symbol != "A.XX" || contents[1].Symbol != "B.XX" {
+		t.Fatalf("got %+v", contents)
+	}
+
+	a, b := contents[0], contents[1]
+	if time.Since(a.QuoteUpdated) > time.Minute || a.HistEntries != 22 || a.Dividends != 2 ||
+		time.Since(a.DividendsUpdated) > time.Minute {
+		t.Errorf("got %+v", a)
+	}
+	day := func(t time.Time) string { return t.Format(FmtDay) }
+	if len(a.Hist) != 2 ||
+		day(a.Hist[0].From) != day(ago(50)) || day(a.Hist[0].To) != day(ago(40)) ||
+		day(a.Hist[1].From) != day(ago(30)) || day(a.Hist[1].To) != day(ago(20)) {
+		t.Errorf("got the ranges %+v", a.Hist)
+	}
+
+	/* B.XX never paid out, but the cache knows that */
+	if !b.QuoteUpdated.IsZero() || len(b.Hist) != 0 || b.HistEntries != 0 || b.Dividends != 0 ||
+		time.Since(b.DividendsUpdated) > time.Minute {
+		t.Errorf("got %+v", b)
+	}
+}
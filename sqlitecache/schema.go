@@ -0,0 +1,260 @@
+package sqlitecache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* every change to the layout of the database gets a migration. They're
+ * run in order, each one only once, and the version of the last one is
+ * stored in the database. Databases from before the versioning have some
+ * of the changes without knowing it, so every migration has to be safe to
+ * run on a database that already has it (CREATE ... IF NOT EXISTS, et
+ * cetera). Adding a column that's already there is skipped.
+ *
+ * The SQL is spelled out instead of derived from the structs that are
+ * stored, those keep changing. Never change or remove a migration, only
+ * add new ones. */
+type migration struct {
+	description string
+	stmts       []string
+}
+
+var migrations = []migration{
+	/* 1 */ {"create the quote and history tables", []string{
+		`CREATE TABLE IF NOT EXISTS quotes (
+			Symbol           VARCHAR(255) NOT NULL PRIMARY KEY,
+			Name             VARCHAR(255),
+			Exchange         VARCHAR(255),
+			Updated          DATETIME,
+			Volume           INTEGER,
+			AvgDailyVolume   INTEGER,
+			PeRatio          REAL,
+			EarningsPerShare REAL,
+			DividendPerShare REAL,
+			DividendYield    REAL,
+			DividendExDate   DATETIME,
+			Bid              REAL,
+			Ask              REAL,
+			Open             REAL,
+			PreviousClose    REAL,
+			LastTradePrice   REAL,
+			DayLow           REAL,
+			DayHigh          REAL,
+			YearLow          REAL,
+			YearHigh         REAL,
+			Ma50             REAL,
+			Ma200            REAL)`,
+		`CREATE TABLE IF NOT EXISTS histquotes (
+			Symbol   VARCHAR(255) NOT NULL,
+			Date     DATETIME NOT NULL,
+			Open     REAL,
+			Close    REAL,
+			AdjClose REAL,
+			High     REAL,
+			Low      REAL,
+			Volume   INTEGER,
+			PRIMARY KEY (Symbol, Date))`,
+	}},
+	/* 2 */ {"index the history by date", []string{
+		/* support date range queries over all symbols */
+		`CREATE INDEX IF NOT EXISTS hq_date_idx ON histquotes (Date)`,
+	}},
+	/* 3 */ {"add the fund fields to the quotes", []string{
+		/* existing rows would get NULLs, which don't scan into a Quote */
+		`ALTER TABLE quotes ADD COLUMN FundType VARCHAR(255) DEFAULT ''`,
+		`ALTER TABLE quotes ADD COLUMN Nav REAL DEFAULT 0`,
+		`ALTER TABLE quotes ADD COLUMN ExpenseRatio REAL DEFAULT 0`,
+		`ALTER TABLE quotes ADD COLUMN TotalAssets REAL DEFAULT 0`,
+		/* the ones that were added on the fly had no default */
+		`UPDATE quotes SET
+			FundType = coalesce(FundType, ''),
+			Nav = coalesce(Nav, 0),
+			ExpenseRatio = coalesce(ExpenseRatio, 0),
+			TotalAssets = coalesce(TotalAssets, 0)`,
+	}},
+	/* 4 */ {"add the estimate, return and dividend growth fields to the quotes", []string{
+		`ALTER TABLE quotes ADD COLUMN DividendGrowth5y REAL DEFAULT 0`,
+		`ALTER TABLE quotes ADD COLUMN PeRatioEst REAL DEFAULT 0`,
+		`ALTER TABLE quotes ADD COLUMN PeRatioRelToIndex REAL DEFAULT 0`,
+		`ALTER TABLE quotes ADD COLUMN EarningsPerShareEst REAL DEFAULT 0`,
+		`ALTER TABLE quotes ADD COLUMN YearReturn REAL DEFAULT 0`,
+		`UPDATE quotes SET
+			DividendGrowth5y = coalesce(DividendGrowth5y, 0),
+			PeRatioEst = coalesce(PeRatioEst, 0),
+			PeRatioRelToIndex = coalesce(PeRatioRelToIndex, 0),
+			EarningsPerShareEst = coalesce(EarningsPerShareEst, 0),
+			YearReturn = coalesce(YearReturn, 0)`,
+	}},
+	/* 5 */ {"keep track of the cached history ranges", []string{
+		`CREATE TABLE IF NOT EXISTS histcoverage (
+			Symbol   TEXT NOT NULL,
+			FirstDay TEXT NOT NULL,
+			LastDay  TEXT NOT NULL,
+			PRIMARY KEY (Symbol, FirstDay))`,
+		/* symbols that were cached before the coverage was kept track of
+		 * have always been fetched in full, so everything between their
+		 * first and last entry is known */
+		`INSERT INTO histcoverage (Symbol, FirstDay, LastDay)
+			SELECT Symbol, substr(min(Date), 1, 10), substr(max(Date), 1, 10)
+			FROM histquotes
+			WHERE Symbol NOT IN (SELECT Symbol FROM histcoverage)
+			GROUP BY Symbol`,
+	}},
+	/* 6 */ {"cache dividends", []string{
+		`CREATE TABLE IF NOT EXISTS dividends (
+			Symbol    VARCHAR(255) NOT NULL,
+			Date      DATETIME NOT NULL,
+			Dividends REAL,
+			PRIMARY KEY (Symbol, Date))`,
+		/* the last time the full dividend history of a symbol was fetched,
+		 * a symbol that never paid out has no dividends but is still
+		 * known */
+		`CREATE TABLE IF NOT EXISTS dividendsfetched (
+			Symbol  TEXT PRIMARY KEY,
+			Fetched INTEGER NOT NULL)`,
+	}},
+	/* 7 */ {"keep snapshots of the quotes", []string{
+		`CREATE TABLE IF NOT EXISTS quotesnapshots (
+			Symbol              VARCHAR(255) NOT NULL,
+			Name                VARCHAR(255),
+			Exchange            VARCHAR(255),
+			Updated             DATETIME NOT NULL,
+			Volume              INTEGER,
+			AvgDailyVolume      INTEGER,
+			PeRatio             REAL,
+			EarningsPerShare    REAL,
+			DividendPerShare    REAL,
+			DividendYield       REAL,
+			DividendExDate      DATETIME,
+			DividendGrowth5y    REAL,
+			PeRatioEst          REAL,
+			PeRatioRelToIndex   REAL,
+			EarningsPerShareEst REAL,
+			Bid                 REAL,
+			Ask                 REAL,
+			Open                REAL,
+			PreviousClose       REAL,
+			LastTradePrice      REAL,
+			DayLow              REAL,
+			DayHigh             REAL,
+			YearLow             REAL,
+			YearHigh            REAL,
+			Ma50                REAL,
+			Ma200               REAL,
+			YearReturn          REAL,
+			FundType            VARCHAR(255),
+			Nav                 REAL,
+			ExpenseRatio        REAL,
+			TotalAssets         REAL,
+			PRIMARY KEY (Symbol, Updated))`,
+	}},
+}
+
+/* the version of the database this package creates */
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+/* SchemaError is returned when a database was made by a more recent
+ * version of gofinance, it would not be safe to use it. */
+type SchemaError struct {
+	Path      string
+	Version   int
+	Supported int
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("sqlitecache: %v has schema version %v, but this version "+
+		"of gofinance only knows up to version %v, please upgrade",
+		e.Path, e.Version, e.Supported)
+}
+
+/* how long to wait for another process that's migrating the same
+ * database */
+const MIGRATE_TIMEOUT = 30 * time.Second
+
+/* brings the database up to the latest version. Every migration runs in
+ * a transaction of its own, together with recording its version, so a
+ * failed one leaves the database as it was. Other processes may be
+ * opening the same database at the same time. */
+func (c *SqliteCache) migrate(path string) error {
+	ctx := context.Background()
+
+	/* a transaction has to stay on one connection */
+	conn, err := c.gorp.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA busy_timeout = %d`, MIGRATE_TIMEOUT.Milliseconds()))
+	if err != nil {
+		return err
+	}
+
+	for {
+		done, err := migrateNext(ctx, conn, path)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+/* runs the migration after the current version, returns whether there
+ * was none left */
+func migrateNext(ctx context.Context, conn *sql.Conn, path string) (bool, error) {
+	/* takes the write lock right away (a plain BEGIN only does so at the
+	 * first write), so no one else can migrate between reading the
+	 * version and recording the next one */
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return false, err
+	}
+
+	done, err := func() (bool, error) {
+		_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schemaversion (
+			Version     INTEGER PRIMARY KEY,
+			Description TEXT NOT NULL,
+			Applied     INTEGER NOT NULL)`)
+		if err != nil {
+			return false, err
+		}
+
+		var version int
+		err = conn.QueryRowContext(ctx, `SELECT coalesce(max(Version), 0) FROM schemaversion`).Scan(&version)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case version > SchemaVersion():
+			return false, &SchemaError{path, version, SchemaVersion()}
+		case version == SchemaVersion():
+			return true, nil
+		}
+
+		v, m := version+1, migrations[version]
+		vprintf("sqlitecache: migrating %v to version %v: %v\n", path, v, m.description)
+		for _, stmt := range m.stmts {
+			_, err := conn.ExecContext(ctx, stmt)
+			if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+				return false, fmt.Errorf("sqlitecache: migration to version %v (%v) failed: %w",
+					v, m.description, err)
+			}
+		}
+
+		_, err = conn.ExecContext(ctx, `INSERT INTO schemaversion (Version, Description, Applied) VALUES (?, ?, ?)`,
+			v, m.description, time.Now().Unix())
+		return false, err
+	}()
+	if err != nil {
+		conn.ExecContext(ctx, `ROLLBACK`)
+		return false, err
+	}
+
+	_, err = conn.ExecContext(ctx, `COMMIT`)
+	return done, err
+}
@@ -0,0 +1,170 @@
+package sqlitecache
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+/* a database as the versions of gofinance from before the schema
+ * versioning left it, with stmts run on top */
+func oldDB(t *testing.T, stmts ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmts = append([]string{
+		`create table if not exists "quotes" ("Symbol" varchar(255) not null primary key, "Name" varchar(255), "Exchange" varchar(255), "Updated" datetime, "Volume" integer, "AvgDailyVolume" integer, "PeRatio" real, "EarningsPerShare" real, "DividendPerShare" real, "DividendYield" real, "DividendExDate" datetime, "Bid" real, "Ask" real, "Open" real, "PreviousClose" real, "LastTradePrice" real, "DayLow" real, "DayHigh" real, "YearLow" real, "YearHigh" real, "Ma50" real, "Ma200" real) ;`,
+		`create table if not exists "histquotes" ("Symbol" varchar(255) not null, "Date" datetime not null, "Open" real, "Close" real, "AdjClose" real, "High" real, "Low" real, "Volume" integer, primary key ("Symbol", "Date")) ;`,
+		`CREATE INDEX IF NOT EXISTS hq_date_idx ON histquotes (Date)`,
+		`INSERT INTO quotes VALUES ('OLD.XX', 'old', '', '2014-01-02 00:00:00+00:00', 0, 0, 0, 0, 0, 0, '0001-01-01 00:00:00+00:00', 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0)`,
+		`INSERT INTO histquotes VALUES ('OLD.XX', '2014-01-02 00:00:00+00:00', 1, 1, 1, 1, 1, 1)`,
+	}, stmts...)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestQuoteColumns(t *testing.T) {
+	/* some of the fields had been added on the fly already */
+	path := oldDB(t, `ALTER TABLE quotes ADD COLUMN "FundType" varchar(255)`)
+	c, err := New(path, newSource(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	q := fquery.Quote{Symbol: "F.XX", Updated: time.Now(), LastTradePrice: 1,
+		FundType: "ETF", Nav: 2, ExpenseRatio: 0.001, YearReturn: 0.1, PeRatioEst: 12}
+	if err := c.mergeQuotes(q); err != nil {
+		t.Fatal(err)
+	}
+	quotes, err := c.loadQuotes([]string{"F.XX", "OLD.XX"})
+	if err != nil || len(quotes) != 2 {
+		t.Fatalf("got %v, %v", quotes, err)
+	}
+	for _, got := range quotes {
+		if got.Symbol == "F.XX" && (got.FundType != "ETF" || got.Nav != 2 || got.YearReturn != 0.1 || got.PeRatioEst != 12) {
+			t.Errorf("the new fields didn't make it: %+v", got)
+		}
+	}
+}
+
+func TestQuoteEstimates(t *testing.T) {
+	path := oldDB(t, `ALTER TABLE quotes ADD COLUMN "YearReturn" real`)
+	src := newSource(1)
+	c, err := New(path, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	want := fquery.Quote{Symbol: "E.XX", Updated: time.Now(), LastTradePrice: 1,
+		DividendGrowth5y: 0.05, PeRatioEst: 14.5, PeRatioRelToIndex: 0.9,
+		EarningsPerShareEst: 2.25, YearReturn: -0.125}
+	if err := c.mergeQuotes(want); err != nil {
+		t.Fatal(err)
+	}
+
+	/* E.XX is fresh, so it comes from the database, only OLD.XX is fetched */
+	quotes, err := c.Quote([]string{"E.XX", "OLD.XX"})
+	if err != nil || len(quotes) != 2 || src.calls("quote") != 1 {
+		t.Fatalf("got %v, %v, asked %v", quotes, err, src.asked)
+	}
+	for _, got := range quotes {
+		if got.Symbol != "E.XX" {
+			continue
+		}
+		if got.DividendGrowth5y != want.DividendGrowth5y || got.PeRatioEst != want.PeRatioEst ||
+			got.PeRatioRelToIndex != want.PeRatioRelToIndex ||
+			got.EarningsPerShareEst != want.EarningsPerShareEst || got.YearReturn != want.YearReturn {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+/* the versions that were recorded, which should be every one once */
+func checkVersions(t *testing.T, c *SqliteCache) {
+	t.Helper()
+	n, err := c.gorp.SelectInt(`SELECT count(*) FROM schemaversion`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.gorp.SelectInt(`SELECT max(Version) FROM schemaversion`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != SchemaVersion() || int(v) != SchemaVersion() {
+		t.Errorf("got %v versions up to %v, want %v", n, v, SchemaVersion())
+	}
+}
+
+func TestMigrateOld(t *testing.T) {
+	c, err := New(oldDB(t), newSource(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkVersions(t, c)
+
+	/* what was there is kept, the history counts as covered */
+	if quotes, err := c.loadQuotes([]string{"OLD.XX"}); err != nil || len(quotes) != 1 {
+		t.Errorf("got %v, %v", quotes, err)
+	}
+	start, end := jan(2), jan(2)
+	if !c.HasHist("OLD.XX", &start, &end) {
+		t.Errorf("the old history isn't covered")
+	}
+
+	/* and everything new can be stored */
+	if _, err := c.Quote([]string{"NEW.XX"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.DividendHist([]string{"NEW.XX"}); err != nil {
+		t.Error(err)
+	}
+	c.SetKeepSnapshots(true)
+	c.SetQuoteExpiry(0)
+	if _, err := c.Quote([]string{"NEW.XX"}); err != nil {
+		t.Error(err)
+	}
+	if snaps, err := c.Snapshots("NEW.XX", time.Now().Add(-time.Hour), time.Now()); err != nil || len(snaps) != 1 {
+		t.Errorf("got %v snapshots, %v", len(snaps), err)
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	for _, path := range []string{filepath.Join(t.TempDir(), "new.db"), oldDB(t)} {
+		var wg sync.WaitGroup
+		caches := make([]*SqliteCache, 4)
+		errs := make([]error, len(caches))
+		for i := range caches {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				caches[i], errs[i] = New(path, newSource(1))
+			}(i)
+		}
+		wg.Wait()
+
+		for i, c := range caches {
+			if errs[i] != nil {
+				t.Errorf("%v: %v", filepath.Base(path), errs[i])
+				continue
+			}
+			checkVersions(t, c)
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package sqlitecache
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+/* when on, every quote fetched from the source is also added to the
+ * snapshots, see Snapshots. Off by default. As opposed to the quotes
+ * table, which only holds the latest one of each symbol, the snapshots
+ * keep every quote that was fetched. Polling a symbol thus builds up an
+ * intraday history of its price, spread, P/E, ... which no source gives. */
+func (c *SqliteCache) SetKeepSnapshots(on bool) {
+	c.keepSnapshots = on
+}
+
+/* the snapshots of symbol taken between start and end, oldest first */
+func (c *SqliteCache) Snapshots(symbol string, start time.Time, end time.Time) ([]fquery.Quote, error) {
+	var quotes []fquery.Quote
+	_, err := c.gorp.Select(&quotes,
+		`SELECT * FROM quotesnapshots WHERE Symbol = ? AND Updated BETWEEN ? AND ? ORDER BY Updated`,
+		symbol, start.UTC(), end.UTC())
+	return quotes, err
+}
+
+/* the snapshots are stored in UTC, sqlite compares dates as text, so
+ * mixing offsets would mess up the order. A snapshot that's already there
+ * (the source returned the same quote twice) is skipped, anything else that
+ * goes wrong is an error. */
+func addSnapshots(tx *sql.Tx, quotes []fquery.Quote) error {
+	insert, err := tx.Prepare(`INSERT INTO quotesnapshots (` + strings.Join(quoteColumns, ", ") + `)
+	                           VALUES (` + placeholders(len(quoteColumns)) + `)
+	                           ON CONFLICT (Symbol, Updated) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, quote := range quotes {
+		quote.Updated = quote.Updated.UTC()
+		if _, err := insert.Exec(quoteValues(&quote)...); err != nil {
+			return fmt.Errorf("could not add a snapshot of %v at %v: %w", quote.Symbol, quote.Updated, err)
+		}
+	}
+	return nil
+}
@@ -1,14 +1,18 @@
 package sqlitecache
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/aktau/gofinance/calendar"
 	"github.com/aktau/gofinance/fquery"
 	"github.com/aktau/gofinance/util"
 	"github.com/coopernurse/gorp"
@@ -28,18 +32,6 @@ type dbHistEntry struct {
 	Volume   int64
 }
 
-type dbHistMeta struct {
-	Symbol string
-	Amount int64
-
-	MinDate int64
-	MaxDate int64
-
-	/* processed versions of MinDate/MaxDate */
-	rMinDate time.Time
-	rMaxDate time.Time
-}
-
 var (
 	VERBOSITY = 0
 )
@@ -47,8 +39,16 @@ var (
 type SqliteCache struct {
 	fquery.Source
 
-	gorp        *gorp.DbMap
-	quoteExpiry time.Duration
+	/* the same source as the embedded one, but cancellable */
+	src fquery.ContextSource
+
+	gorp           *gorp.DbMap
+	quoteExpiry    time.Duration
+	dividendExpiry time.Duration
+	staleOnError   bool
+	keepSnapshots  bool
+
+	flights flights
 }
 
 func New(path string, src fquery.Source) (*SqliteCache, error) {
@@ -64,22 +64,22 @@ func New(path string, src fquery.Source) (*SqliteCache, error) {
 
 	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
 	if VERBOSITY >= 2 {
-		dbmap.TraceOn("", log.New(os.Stdout, "dbmap: ", log.Lmicroseconds))
+		dbmap.TraceOn("", log.New(os.Stderr, "dbmap: ", log.Lmicroseconds))
 	}
 
-	c := &SqliteCache{src, dbmap, 30 * time.Second}
+	c := &SqliteCache{
+		Source:         src,
+		src:            fquery.WithContext(src),
+		gorp:           dbmap,
+		quoteExpiry:    30 * time.Second,
+		dividendExpiry: DIVIDEND_EXPIRY,
+	}
 
 	c.gorp.AddTableWithName(fquery.Quote{}, "quotes").SetKeys(false, "Symbol")
 	c.gorp.AddTableWithName(dbHistEntry{}, "histquotes").SetKeys(false, "Symbol", "Date")
+	c.gorp.AddTableWithName(dbDividendEntry{}, "dividends").SetKeys(false, "Symbol", "Date")
 
-	err = c.gorp.CreateTablesIfNotExists()
-	if err != nil {
-		c.Close()
-		return nil, err
-	}
-
-	/* support date range queries over all symbols */
-	_, err = c.gorp.Exec(`CREATE INDEX IF NOT EXISTS hq_date_idx ON histquotes (Date)`)
+	err = c.migrate(path)
 	if err != nil {
 		c.Close()
 		return nil, err
@@ -92,37 +92,39 @@ func (c *SqliteCache) SetQuoteExpiry(dur time.Duration) {
 	c.quoteExpiry = dur
 }
 
-func (c *SqliteCache) HasQuote(symbol string) bool {
-	return false
-}
-
-func (c *SqliteCache) HasHist(symbol string, start *time.Time, end *time.Time) bool {
-	return false
+/* when on, quotes and history that can't be refreshed because the source
+ * fails are returned anyway, however old they are, with their Stale field
+ * set. The errors are still returned. Off by default. */
+func (c *SqliteCache) SetStaleOnError(on bool) {
+	c.staleOnError = on
 }
 
 func (c *SqliteCache) Close() error {
 	return c.gorp.Db.Close()
 }
 
-/* TODO: escape your strings, symbols could be user input */
 func (c *SqliteCache) Quote(symbols []string) ([]fquery.Quote, error) {
+	return c.QuoteContext(context.Background(), symbols)
+}
+
+func (c *SqliteCache) QuoteContext(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
 	/* fetch all the quotes we have */
-	quotedSymbols := quoteSymbols(symbols)
-
-	var results []fquery.Quote
-	cutoff := time.Now().Add(-c.quoteExpiry)
-	_, err := c.gorp.Select(&results,
-		fmt.Sprintf(
-			"SELECT * FROM quotes WHERE Symbol IN (%v) AND Updated >= datetime(%v, 'unixepoch')",
-			strings.Join(quotedSymbols, ","), cutoff.Unix()))
+	cached, err := c.loadQuotes(symbols)
 	if err != nil {
 		/* if an error occured, just patch through to the source */
 		vprintln("sqlitecache: error while fetching quotes, ", err, ", will use underlying source")
-		return c.Source.Quote(symbols)
+		quotes, err := c.src.QuoteContext(ctx, symbols)
+		return quotes, fquery.PerSymbol(err, symbols).Err()
 	}
 
 	/* in case no error occured, check which ones were not in the cache,
 	 * they need to be added to the list of quotes to fetch from the src */
+	results := make([]fquery.Quote, 0, len(symbols))
+	for i := range cached {
+		if !c.expired(&cached[i]) {
+			results = append(results, cached[i])
+		}
+	}
 	quoteMap := fquery.QuotesToMap(results)
 	toFetch := make([]string, 0, len(symbols))
 	for _, symbol := range symbols {
@@ -136,260 +138,531 @@ func (c *SqliteCache) Quote(symbols []string) ([]fquery.Quote, error) {
 
 	// Fetch all missing items, store in cache and add to the results we already
 	// got from the cache. If there's an error, still try to add as many
-	// non-erroneous results as possible.
-	fetched, err := c.Source.Quote(toFetch)
-	results = append(results, fetched...)
-	if err := c.mergeQuotes(fetched...); err != nil {
-		vprintf("sqlitecache: error, could not merge quotes of %v into cache, %v\n", toFetch, err)
+	// non-erroneous results as possible. Symbols that someone else is
+	// already fetching are read back from the cache once they're in.
+	errs, landed := c.flights.do(ctx, fquery.ActionQuote, toFetch, func(mine []string) fquery.SymbolErrors {
+		fetched, err := c.src.QuoteContext(ctx, mine)
+		results = append(results, fetched...)
+		if err := c.mergeQuotes(fetched...); err != nil {
+			vprintf("sqlitecache: error, could not merge quotes of %v into cache, %v\n", mine, err)
+		}
+		return fquery.PerSymbol(err, mine)
+	}, nil)
+	if len(landed) > 0 {
+		results = append(results, c.landedQuotes(landed, errs)...)
+	}
+
+	if c.staleOnError && len(errs) > 0 {
+		results = append(results, c.staleQuotes(errs.Symbols())...)
 	}
-	return results, err
+	return results, errs.Err()
 }
 
-/* I consider this to be an extremely dirty function, it should be split up
- * and possibly (hopefully) simplified*/
-func (c *SqliteCache) Hist(symbols []string) (map[string]fquery.Hist, error) {
-	/* fetch all the historical results we have in the cache */
-	symbolSet := sliceToSet(symbols)
-
-	/* find out how many entries we have per symbol (we do this so we can
-	 * process the cached symbols and fetch the missing ones in parallel */
-	sqliteCastToUnix := func(selector string) string {
-		return `strftime('%s',` + selector + `)`
-	}
-	var meta []*dbHistMeta
-	_, err := c.gorp.Select(&meta,
-		fmt.Sprintf(
-			`SELECT Symbol, count(Symbol) AS Amount,
-			        %v AS MinDate,
-					%v AS MaxDate
-			 FROM histquotes
-			 WHERE Symbol IN (%v)
-			 GROUP BY Symbol`,
-			sqliteCastToUnix("min(Date)"),
-			sqliteCastToUnix("max(Date)"),
-			strings.Join(quoteSymbols(symbols), ",")))
+/* quotes expire after the quote expiry, but only if the market has been
+ * open since, there's no point in fetching the same closing price over and
+ * over during the weekend */
+func (c *SqliteCache) expired(q *fquery.Quote) bool {
+	return calendar.Expired(q.Symbol, q.Updated, c.quoteExpiry, time.Now())
+}
+
+/* the quotes that were fetched by someone else, the ones that didn't
+ * make it into the cache are added to errs */
+func (c *SqliteCache) landedQuotes(symbols []string, errs fquery.SymbolErrors) []fquery.Quote {
+	quotes, err := c.loadQuotes(symbols)
 	if err != nil {
-		/* if an error occured, just patch through to the source */
-		vprintln("sqlitecache: error while fetching history metadata, ", err, ", will use underlying source")
-		return c.Source.Hist(symbols)
+		vprintln("sqlitecache: error while fetching quotes, ", err)
 	}
 
-	/* convert the timestamps from UNIX timestamps to time.Time */
-	for _, m := range meta {
-		m.rMinDate = time.Unix(m.MinDate, 0)
-		m.rMaxDate = time.Unix(m.MaxDate, 0)
+	found := fquery.QuotesToMap(quotes)
+	for _, symbol := range symbols {
+		if _, ok := found[symbol]; !ok {
+			errs.Add(symbol, fquery.KindOther, errNotLanded)
+		}
 	}
+	return quotes
+}
 
-	/* convert the meta list to a map, for easier searching */
-	metaMap := make(map[string]*dbHistMeta, len(meta))
-	for _, m := range meta {
-		metaMap[m.Symbol] = m
+/* the cached quotes of symbols, expired or not, marked as stale */
+func (c *SqliteCache) staleQuotes(symbols []string) []fquery.Quote {
+	quotes, err := c.loadQuotes(symbols)
+	if err != nil {
+		vprintln("sqlitecache: error while fetching stale quotes,", err)
+		return nil
 	}
 
-	yesterday := Yesterday()
-	missing := make([]string, 0, len(symbols))
-	for _, s := range symbols {
-		m, found := metaMap[s]
-		if !found {
-			vprintln(s, "was NOT fetched from cache!")
-			missing = append(missing, s)
-		} else {
-			if m.rMaxDate.Before(yesterday) {
-				vprintln(s, "was NOT fetched from cache! (historical prices "+
-					"not recent enough), last available date was",
-					m.rMaxDate.Format("02/01/2006"))
-				missing = append(missing, s)
-				delete(symbolSet, s)
+	for i := range quotes {
+		quotes[i].Stale = time.Since(quotes[i].Updated)
+		vprintln(quotes[i].Symbol, "was fetched from cache, stale by", quotes[i].Stale)
+	}
+	return quotes
+}
+
+/* the cached quotes of symbols, expired or not */
+func (c *SqliteCache) loadQuotes(symbols []string) ([]fquery.Quote, error) {
+	var quotes []fquery.Quote
+	for _, chunk := range chunks(symbols) {
+		_, err := c.gorp.Select(&quotes,
+			`SELECT * FROM quotes WHERE Symbol IN (`+placeholders(len(chunk))+`)`,
+			args(chunk)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return quotes, nil
+}
+
+func (c *SqliteCache) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	return c.HistContext(context.Background(), symbols)
+}
+
+/* symbols the cache has never seen are fetched in full, for the others
+ * only the days since the last time are asked for */
+func (c *SqliteCache) HistContext(ctx context.Context, symbols []string) (map[string]fquery.Hist, error) {
+	cov, err := c.coverage(symbols)
+	if err != nil {
+		/* if an error occured, just patch through to the source */
+		vprintln("sqlitecache: error while fetching history coverage, ", err, ", will use underlying source")
+		hist, err := c.src.HistContext(ctx, symbols)
+		return hist, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	/* the symbols whose coverage doesn't reach the last day it can */
+	stale := func(cov map[string][]interval, symbols []string) []string {
+		var stale []string
+		for _, s := range symbols {
+			ivs := cov[s]
+			if len(ivs) == 0 || ivs[len(ivs)-1].End.Before(lastCoverableDay(s)) {
+				stale = append(stale, s)
 			} else {
 				vprintln(s, "was fetched from cache!")
 			}
 		}
+		return stale
 	}
 
-	hist := make(map[string]fquery.Hist, len(symbols))
-	var (
-		histmutex sync.Mutex
-		wg        sync.WaitGroup
-	)
-
-	if len(symbolSet) > 0 {
-		adjSymbols := setToSlice(symbolSet)
-		vprintln("fetching", adjSymbols, "from the SQLite db")
-
-		/* now query the actual entries */
-		var results []dbHistEntry
-		_, err = c.gorp.Select(&results,
-			fmt.Sprintf(
-				`SELECT * FROM histquotes WHERE Symbol IN (%v) ORDER BY Symbol, Date`,
-				strings.Join(quoteSymbols(adjSymbols), ",")))
+	errs, _ := c.flights.do(ctx, fquery.ActionHist, stale(cov, symbols), func(mine []string) fquery.SymbolErrors {
+		/* someone else may have fetched part of it in the meantime */
+		cov, err := c.coverage(mine)
 		if err != nil {
-			/* if an error occured, just patch through to the source */
-			vprintln("sqlitecache: error while fetching historical quotes, ", err, ", will use underlying source")
-			return c.Source.Hist(symbols)
-		}
-
-		/* process the dbHistEntries into fquery.Hist structures */
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			var (
-				cursymb string
-				curhist fquery.Hist
-			)
-			for _, e := range results {
-				/* detect transitions between symbols */
-				if cursymb != e.Symbol {
-					/* if this is not the first iteration, finish up the hist
-					 * entry we've been working on */
-					if len(cursymb) != 0 {
-						histmutex.Lock()
-						hist[cursymb] = curhist
-						histmutex.Unlock()
-					}
-
-					/* move to the following symbol */
-					info := metaMap[e.Symbol]
-					cursymb = e.Symbol
-					curhist = fquery.Hist{
-						Symbol:  e.Symbol,
-						From:    info.rMinDate,
-						To:      info.rMaxDate,
-						Entries: make([]fquery.HistEntry, 0, info.Amount),
-					}
-				}
+			return fquery.PerSymbol(err, mine)
+		}
 
-				curhist.Entries = append(curhist.Entries, normalizeHistEntry(&e))
+		var (
+			full  []string
+			tails = make(map[time.Time][]string)
+		)
+		for _, s := range mine {
+			ivs := cov[s]
+			if len(ivs) == 0 {
+				vprintln(s, "was NOT fetched from cache!")
+				full = append(full, s)
+			} else {
+				from := nextDay(ivs[len(ivs)-1].End)
+				vprintln(s, "was partially fetched from cache, missing everything since", from.Format(FmtDay))
+				tails[from] = append(tails[from], s)
 			}
+		}
 
-			/* add the last one too */
-			histmutex.Lock()
-			hist[cursymb] = curhist
-			histmutex.Unlock()
-		}()
+		f := c.newFetch(ctx)
+		if len(full) > 0 {
+			f.full(full)
+		}
+		for from, syms := range tails {
+			f.window(syms, interval{from, dayOf(time.Now())}, cov)
+		}
+		return f.wait()
+	}, func(symbols []string) []string {
+		cov, err := c.coverage(symbols)
+		if err != nil {
+			return nil
+		}
+		return stale(cov, symbols)
+	})
+
+	hist, err := c.loadHist(symbols, interval{})
+	if err != nil {
+		vprintln("sqlitecache: error while fetching historical quotes, ", err, ", will use underlying source")
+		hist, err := c.src.HistContext(ctx, symbols)
+		return hist, fquery.PerSymbol(err, symbols).Err()
 	}
 
-	/* fetch the missing entries from the original source */
-	if len(missing) > 0 {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	return c.dropFailed(hist, errs, cov)
+}
 
-			/* fetch the missing symbols */
-			missingMap, err := c.Source.Hist(missing)
-			if err != nil {
-				vprintln("sqlitecache: error occured while fetching missing", missing, "hist. quotes,", err)
-			} else {
-				missinglist := make([]fquery.Hist, 0, len(missingMap))
-				for symbol, history := range missingMap {
-					histmutex.Lock()
-					hist[symbol] = history
-					histmutex.Unlock()
+func (c *SqliteCache) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	return c.HistLimitContext(context.Background(), symbols, start, end)
+}
 
-					missinglist = append(missinglist, history)
-				}
+/* only the parts of [start, end] that the cache doesn't have yet are asked
+ * from the source, symbols that miss the same part share a request */
+func (c *SqliteCache) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	want := interval{dayOf(start), dayOf(end)}
 
-				/* add the previously missing items to the cache, so we don't have to
-				 * request them again */
-				c.mergeHistory(missinglist...)
+	cov, err := c.coverage(symbols)
+	if err != nil {
+		vprintln("sqlitecache: error while fetching history coverage, ", err, ", will use underlying source")
+		hist, err := c.src.HistLimitContext(ctx, symbols, start, end)
+		return hist, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	/* the symbols that miss part of the window, up to the last day that
+	 * can be covered */
+	stale := func(cov map[string][]interval, symbols []string) []string {
+		var stale []string
+		for _, s := range symbols {
+			if len(missing(cov[s], interval{want.Start, minTime(want.End, lastCoverableDay(s))})) > 0 {
+				stale = append(stale, s)
+			} else if len(cov[s]) > 0 && len(missing(cov[s], want)) == 0 {
+				vprintln(s, "was fetched from cache!")
+			}
+		}
+		return stale
+	}
+
+	errs, _ := c.flights.do(ctx, fquery.ActionHist, stale(cov, symbols), func(mine []string) fquery.SymbolErrors {
+		/* someone else may have fetched part of it in the meantime */
+		cov, err := c.coverage(mine)
+		if err != nil {
+			return fquery.PerSymbol(err, mine)
+		}
+
+		/* today can't be covered, but if there's something to fetch
+		 * anyway, it might as well include it */
+		gaps := make(map[interval][]string)
+		for _, s := range mine {
+			last := lastCoverableDay(s)
+			for _, gap := range missing(cov[s], interval{want.Start, minTime(want.End, last)}) {
+				if !gap.End.Before(last) {
+					gap.End = want.End
+				}
+				gaps[gap] = append(gaps[gap], s)
 			}
-		}()
+		}
+
+		f := c.newFetch(ctx)
+		for gap, syms := range gaps {
+			vprintln("sqlitecache: fetching", syms, "from", gap.Start.Format(FmtDay), "to", gap.End.Format(FmtDay))
+			f.window(syms, gap, cov)
+		}
+		return f.wait()
+	}, func(symbols []string) []string {
+		cov, err := c.coverage(symbols)
+		if err != nil {
+			return nil
+		}
+		return stale(cov, symbols)
+	})
+
+	hist, err := c.loadHist(symbols, want)
+	if err != nil {
+		vprintln("sqlitecache: error while fetching historical quotes, ", err, ", will use underlying source")
+		hist, err := c.src.HistLimitContext(ctx, symbols, start, end)
+		return hist, fquery.PerSymbol(err, symbols).Err()
+	}
+
+	return c.dropFailed(hist, errs, cov)
+}
+
+/* the symbols that couldn't be brought up to date are left out, whatever
+ * the cache has on them is incomplete. Unless stale history was asked for,
+ * then it's marked with the time since the end of what the cache had
+ * beforehand (cov). */
+func (c *SqliteCache) dropFailed(hist map[string]fquery.Hist, errs fquery.SymbolErrors, cov map[string][]interval) (map[string]fquery.Hist, error) {
+	for symbol := range errs {
+		h, ok := hist[symbol]
+		ivs := cov[symbol]
+		if !c.staleOnError || !ok || len(ivs) == 0 {
+			delete(hist, symbol)
+			continue
+		}
+
+		h.Stale = time.Since(nextDay(ivs[len(ivs)-1].End))
+		hist[symbol] = h
+		vprintln(symbol, "history was fetched from cache, stale by", h.Stale)
+	}
+	return hist, errs.Err()
+}
+
+/* reads the history of symbols from the cache, limited to the days in iv
+ * unless it's the zero interval */
+func (c *SqliteCache) loadHist(symbols []string, iv interval) (map[string]fquery.Hist, error) {
+	var extra []interface{}
+	cond := ``
+	if !iv.Start.IsZero() {
+		cond = ` AND substr(Date, 1, 10) BETWEEN ? AND ?`
+		extra = append(extra, iv.Start.Format(FmtDay), iv.End.Format(FmtDay))
 	}
 
-	wg.Wait()
+	/* the chunks don't share symbols, so every symbol still has its
+	 * entries in one run */
+	var results []dbHistEntry
+	for _, chunk := range chunks(symbols) {
+		query := `SELECT * FROM histquotes WHERE Symbol IN (` + placeholders(len(chunk)) + `)` +
+			cond + ` ORDER BY Symbol, Date`
+		if _, err := c.gorp.Select(&results, query, args(chunk, extra...)...); err != nil {
+			return nil, err
+		}
+	}
+
+	hist := make(map[string]fquery.Hist, len(symbols))
+	for i := 0; i < len(results); {
+		symbol := results[i].Symbol
+		h := fquery.Hist{Symbol: symbol, From: results[i].Date}
+		for ; i < len(results) && results[i].Symbol == symbol; i++ {
+			h.Entries = append(h.Entries, normalizeHistEntry(&results[i]))
+		}
+		h.To = h.Entries[len(h.Entries)-1].Date.GetTime()
+		hist[symbol] = h
+	}
 
 	return hist, nil
 }
 
-/*
-func (c *SqliteCache) HistLimit(symbol []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
-	return nil, fmt.Errorf("not supported")
+/* fetches history from the source in parallel and stores it, together
+ * with the range it covers */
+type histFetch struct {
+	c   *SqliteCache
+	ctx context.Context
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs fquery.SymbolErrors
 }
 
-func (c *SqliteCache) DividendHist(symbol []string) (map[string]fquery.DividendHist, error) {
-	return nil, fmt.Errorf("not supported")
+func (c *SqliteCache) newFetch(ctx context.Context) *histFetch {
+	return &histFetch{c: c, ctx: ctx, errs: make(fquery.SymbolErrors)}
 }
 
-func (c *SqliteCache) DividendHistLimit(symbol []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
-	return nil, fmt.Errorf("not supported")
+/* all history of symbols, so everything up to now is covered */
+func (f *histFetch) full(symbols []string) {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		hists, err := f.c.src.HistContext(f.ctx, symbols)
+		covered := make(map[string]interval, len(hists))
+		for symbol, h := range hists {
+			covered[symbol] = interval{dayOf(h.From), lastCoverableDay(symbol)}
+		}
+		f.store(symbols, hists, covered, err)
+	}()
+}
+
+/* the history of symbols in iv, falls back to fetching everything if the
+ * source can't limit it. cov is what the cache had beforehand. */
+func (f *histFetch) window(symbols []string, iv interval, cov map[string][]interval) {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		var (
+			hists map[string]fquery.Hist
+			err   error
+		)
+		if f.c.src.Capabilities().Supports(fquery.ActionHistLimit) {
+			/* the end is inclusive, so ask up to the end of that day */
+			hists, err = f.c.src.HistLimitContext(f.ctx, symbols, iv.Start, iv.End.Add(day-time.Second))
+		} else {
+			hists, err = f.c.src.HistContext(f.ctx, symbols)
+		}
+
+		/* sources tend to say they don't know a symbol when there's simply
+		 * no data in the window (e.g.: only a weekend was missing), that's
+		 * not an error for symbols the cache already knows */
+		errs := make(fquery.SymbolErrors)
+		errs.Merge(err, symbols...)
+		for symbol, serr := range errs {
+			if serr.Kind == fquery.KindUnknownSymbol && len(cov[symbol]) > 0 {
+				delete(errs, symbol)
+			}
+		}
+
+		covered := make(map[string]interval, len(symbols))
+		for _, symbol := range symbols {
+			if _, failed := errs[symbol]; !failed {
+				covered[symbol] = interval{iv.Start, minTime(iv.End, lastCoverableDay(symbol))}
+			}
+		}
+		f.store(symbols, hists, covered, errs.Err())
+	}()
+}
+
+func (f *histFetch) store(symbols []string, hists map[string]fquery.Hist, covered map[string]interval, err error) {
+	if err != nil {
+		vprintln("sqlitecache: error occured while fetching", symbols, "hist. quotes,", err)
+		f.mu.Lock()
+		f.errs.Merge(err, symbols...)
+		f.mu.Unlock()
+	}
+
+	if err := f.c.storeHist(hists, covered); err != nil {
+		vprintln("sqlitecache: error, could not merge history into cache,", err)
+	}
+}
+
+func (f *histFetch) wait() fquery.SymbolErrors {
+	f.wg.Wait()
+	return f.errs
 }
-*/
 
 func (c *SqliteCache) String() string {
-	return "SQLite cache, backed by: " + c.String()
+	return "SQLite cache, backed by: " + c.Source.String()
 }
 
+/* stores the latest quote of every symbol (and a snapshot of it, if
+ * they're kept) in one go */
 func (c *SqliteCache) mergeQuotes(quotes ...fquery.Quote) error {
 	if len(quotes) == 0 {
 		return nil
 	}
 
-	tx, err := c.gorp.Begin()
+	tx, err := c.gorp.Db.Begin()
 	if err != nil {
 		return err
 	}
 
-	for _, quote := range quotes {
-		vprintln("merging quote: ", quote.Symbol)
-		count, err := tx.Update(&quote)
-		if err == nil && count == 1 {
-			continue
+	upsert, err := tx.Prepare(`INSERT OR REPLACE INTO quotes (` + strings.Join(quoteColumns, ", ") + `)
+	                           VALUES (` + placeholders(len(quoteColumns)) + `)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer upsert.Close()
+
+	for i := range quotes {
+		vprintln("merging quote:", quotes[i].Symbol)
+		if _, err := upsert.Exec(quoteValues(&quotes[i])...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not store the quote of %v: %w", quotes[i].Symbol, err)
 		}
-		vprintln("sqlitecache: error while UPDATE'ing symbol", quote.Symbol,
-			"err:", err, ", count:", count)
+	}
 
-		/* update didn't work, so try insert */
-		err = tx.Insert(&quote)
-		if err != nil {
-			vprintln("sqlitecache: error while INSERTing", err)
+	if c.keepSnapshots {
+		if err := addSnapshots(tx, quotes); err != nil {
+			tx.Rollback()
+			return err
 		}
 	}
 
 	return tx.Commit()
 }
 
-func (c *SqliteCache) mergeHistory(hists ...fquery.Hist) error {
-	if len(hists) == 0 {
+/* the columns of the quotes and the snapshots, one per stored field of
+ * fquery.Quote, in the order of the fields */
+var quoteColumns = func() []string {
+	var cols []string
+	typ := reflect.TypeOf(fquery.Quote{})
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Tag.Get("db") != "-" {
+			cols = append(cols, typ.Field(i).Name)
+		}
+	}
+	return cols
+}()
+
+/* the values of q that go in quoteColumns */
+func quoteValues(q *fquery.Quote) []interface{} {
+	v := reflect.ValueOf(q).Elem()
+	vals := make([]interface{}, 0, len(quoteColumns))
+	for _, col := range quoteColumns {
+		vals = append(vals, v.FieldByName(col).Interface())
+	}
+	return vals
+}
+
+/* the amount of rows inserted per statement, sqlite allows at most 999
+ * parameters in one statement and every row has 8 */
+const HIST_BATCH_SIZE = 100
+
+/* replaces the stored history of every hist in the range it covers, and
+ * adds the covered ranges to what the cache knows about */
+func (c *SqliteCache) storeHist(hists map[string]fquery.Hist, covered map[string]interval) error {
+	if len(hists) == 0 && len(covered) == 0 {
 		return nil
 	}
 
-	tx, err := c.gorp.Begin()
+	tx, err := c.gorp.Db.Begin()
 	if err != nil {
 		return err
 	}
 
+	del, err := tx.Prepare(`DELETE FROM histquotes WHERE Symbol = ? AND substr(Date, 1, 10) BETWEEN ? AND ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer del.Close()
+
+	/* one statement per batch size, in practice there's the full one and
+	 * the one for the remainder of every symbol */
+	inserts := make(map[int]*sql.Stmt)
+	defer func() {
+		for _, stmt := range inserts {
+			stmt.Close()
+		}
+	}()
+	insert := func(rows []fquery.HistEntry, symbol string) error {
+		stmt, ok := inserts[len(rows)]
+		if !ok {
+			row := "(?,?,?,?,?,?,?,?)"
+			query := `INSERT OR REPLACE INTO histquotes
+			          (Symbol, Date, Open, Close, AdjClose, High, Low, Volume)
+			          VALUES ` + strings.Repeat(row+",", len(rows)-1) + row
+			if stmt, err = tx.Prepare(query); err != nil {
+				return err
+			}
+			inserts[len(rows)] = stmt
+		}
+
+		vals := make([]interface{}, 0, 8*len(rows))
+		for _, e := range rows {
+			vals = append(vals, symbol, time.Time(e.Date), e.Open, e.Close,
+				e.AdjClose, e.High, e.Low, e.Volume)
+		}
+		_, err := stmt.Exec(vals...)
+		return err
+	}
+
 	for _, hist := range hists {
-		/* delete values we're going to override anyway */
+		if len(hist.Entries) == 0 {
+			continue
+		}
+
+		/* delete values we're going to override anyway, so that entries the
+		 * source no longer has don't linger */
 		vprintln("deleting potentially already stored history for symbol",
 			hist.Symbol, "from", hist.From, "to", hist.To)
-		delquery := `DELETE
-				     FROM  histquotes
-					 WHERE Symbol = '%v'
-					 AND   Date BETWEEN datetime(%v, 'unixepoch')
-							    AND     datetime(%v, 'unixepoch')`
-		delquery = fmt.Sprintf(delquery, hist.Symbol, hist.From.Unix(), hist.To.Unix())
-		_, err := tx.Exec(delquery)
-		if err != nil {
-			vprintln("sqlitecache: mergeHistory: error while deleting, query:",
-				delquery, "error:", err)
+		if _, err := del.Exec(hist.Symbol, hist.From.Format(FmtDay), hist.To.Format(FmtDay)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not delete history of %v: %w", hist.Symbol, err)
 		}
 
 		vprintln("merging history:", hist.Symbol)
-		for _, entry := range hist.Entries {
-			/* insert */
-			err := tx.Insert(newDbHistEntry(hist.Symbol, &entry))
-			if err != nil {
-				vprintln("sqlitecache: mergeHistory: error while INSERTing", err)
+		for start := 0; start < len(hist.Entries); start += HIST_BATCH_SIZE {
+			end := start + HIST_BATCH_SIZE
+			if end > len(hist.Entries) {
+				end = len(hist.Entries)
+			}
+			if err := insert(hist.Entries[start:end], hist.Symbol); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("could not insert history of %v: %w", hist.Symbol, err)
 			}
 		}
 	}
 
+	for symbol, iv := range covered {
+		if err := addCoverage(tx, symbol, iv); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not store coverage of %v: %w", symbol, err)
+		}
+	}
+
 	return tx.Commit()
 }
 
 func vprintln(a ...interface{}) (int, error) {
 	if VERBOSITY > 0 {
-		return fmt.Println(a...)
+		return fmt.Fprintln(os.Stderr, a...)
 	}
 
 	return 0, nil
@@ -397,16 +670,46 @@ func vprintln(a ...interface{}) (int, error) {
 
 func vprintf(format string, a ...interface{}) (int, error) {
 	if VERBOSITY > 0 {
-		return fmt.Printf(format, a...)
+		return fmt.Fprintf(os.Stderr, format, a...)
 	}
 
 	return 0, nil
 }
 
-func quoteSymbols(symbols []string) []string {
-	return util.MapStr(func(s string) string {
-		return `'` + s + `'`
-	}, symbols)
+/* the most symbols that go in one IN (...) clause: sqlite versions
+ * before 3.32 allow at most 999 parameters per statement, some are left
+ * for the rest of the query */
+const QUERY_SYMBOLS = 900
+
+/* splits symbols in parts that each fit in an IN (...) clause */
+func chunks(symbols []string) [][]string {
+	var parts [][]string
+	for len(symbols) > QUERY_SYMBOLS {
+		parts = append(parts, symbols[:QUERY_SYMBOLS])
+		symbols = symbols[QUERY_SYMBOLS:]
+	}
+	if len(symbols) > 0 {
+		parts = append(parts, symbols)
+	}
+	return parts
+}
+
+/* "?,?,?" for n == 3, to go in an IN (...) clause */
+func placeholders(n int) string {
+	if n == 0 {
+		/* IN () is valid in sqlite, but a lone ? isn't */
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+/* turns symbols (and extra) into arguments for a query */
+func args(symbols []string, extra ...interface{}) []interface{} {
+	xs := make([]interface{}, 0, len(symbols)+len(extra))
+	for _, s := range symbols {
+		xs = append(xs, s)
+	}
+	return append(xs, extra...)
 }
 
 func normalizeHistEntry(e *dbHistEntry) fquery.HistEntry {
@@ -434,25 +737,19 @@ func newDbHistEntry(symbol string, e *fquery.HistEntry) *dbHistEntry {
 	}
 }
 
+const day = 24 * time.Hour
+
+/* the fetch that someone else did for us succeeded, but it didn't end up
+ * in the cache */
+var errNotLanded = errors.New("fetched, but could not be stored in the cache")
+
 func Yesterday() time.Time {
-	const day = 24 * time.Hour
 	return time.Now().Add(-1 * day)
 }
 
-func sliceToSet(xs []string) map[string]bool {
-	m := make(map[string]bool, len(xs))
-	for _, x := range xs {
-		m[x] = true
-	}
-	return m
-}
-
-func setToSlice(m map[string]bool) []string {
-	xs := make([]string, len(m))
-	idx := 0
-	for str := range m {
-		xs[idx] = str
-		idx++
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
 	}
-	return xs
+	return b
 }
@@ -0,0 +1,436 @@
+package sqlitecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+var (
+	_ fquery.Cache       = &SqliteCache{}
+	_ fquery.Inspectable = &SqliteCache{}
+)
+
+/* a source that knows every symbol, with a daily close for every day of
+ * the last days days. It counts what it's asked for, and fails everything
+ * while fail is set. If release is set, every call reports on started and
+ * then waits until release is closed. Every symbol paid two dividends,
+ * unless dividends is set. */
+type source struct {
+	days      int
+	fail      bool
+	started   chan string
+	release   chan struct{}
+	dividends map[string][]fquery.DividendEntry
+
+	mu     sync.Mutex
+	asked  map[string][]string
+	limits []interval
+}
+
+func newSource(days int) *source {
+	return &source{days: days, asked: make(map[string][]string)}
+}
+
+func (s *source) ask(action string, symbols []string) error {
+	s.mu.Lock()
+	s.asked[action] = append(s.asked[action], symbols...)
+	release := s.release
+	s.mu.Unlock()
+
+	if release != nil {
+		s.started <- action
+		<-release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("offline")
+	}
+	return nil
+}
+
+/* makes calls wait until the returned function is (first) called */
+func (s *source) hold() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = make(chan string, 100)
+	s.release = make(chan struct{})
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.release != nil {
+			close(s.release)
+			s.release = nil
+		}
+	}
+}
+
+func (s *source) calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.asked[action])
+}
+
+func (s *source) hist(symbol string, start, end time.Time) fquery.Hist {
+	h := fquery.Hist{Symbol: symbol}
+	for t := dayOf(start); !t.After(end); t = nextDay(t) {
+		h.Entries = append(h.Entries, fquery.HistEntry{
+			Date:  util.YearMonthDay(t),
+			Close: float64(t.YearDay()),
+		})
+	}
+	if len(h.Entries) > 0 {
+		h.From, h.To = h.Entries[0].Date.GetTime(), h.Entries[len(h.Entries)-1].Date.GetTime()
+	}
+	return h
+}
+
+func (s *source) Quote(symbols []string) ([]fquery.Quote, error) {
+	if err := s.ask("quote", symbols); err != nil {
+		return nil, err
+	}
+	var quotes []fquery.Quote
+	for _, symbol := range symbols {
+		quotes = append(quotes, fquery.Quote{Symbol: symbol, LastTradePrice: 10, Updated: time.Now()})
+	}
+	return quotes, nil
+}
+
+func (s *source) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	if err := s.ask("hist", symbols); err != nil {
+		return nil, err
+	}
+	today := dayOf(time.Now())
+	hists := make(map[string]fquery.Hist)
+	for _, symbol := range symbols {
+		hists[symbol] = s.hist(symbol, today.AddDate(0, 0, -s.days), today)
+	}
+	return hists, nil
+}
+
+func (s *source) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	s.mu.Lock()
+	s.limits = append(s.limits, interval{dayOf(start), dayOf(end)})
+	s.mu.Unlock()
+	if err := s.ask("histlimit", symbols); err != nil {
+		return nil, err
+	}
+	hists := make(map[string]fquery.Hist)
+	for _, symbol := range symbols {
+		hists[symbol] = s.hist(symbol, start, end)
+	}
+	return hists, nil
+}
+
+func (s *source) paid(symbols []string) map[string]fquery.DividendHist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	today := dayOf(time.Now())
+	divs := make(map[string]fquery.DividendHist)
+	for _, symbol := range symbols {
+		d := fquery.DividendHist{Symbol: symbol, Dividends: []fquery.DividendEntry{
+			{Date: util.YearMonthDay(today.AddDate(0, -6, 0)), Dividends: 0.5},
+			{Date: util.YearMonthDay(today.AddDate(0, -1, 0)), Dividends: 0.6},
+		}}
+		if s.dividends != nil {
+			d.Dividends = s.dividends[symbol]
+		}
+		divs[symbol] = d
+	}
+	return divs
+}
+
+func (s *source) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
+	if err := s.ask("dividends", symbols); err != nil {
+		return nil, err
+	}
+	return s.paid(symbols), nil
+}
+
+func (s *source) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	if err := s.ask("dividendslimit", symbols); err != nil {
+		return nil, err
+	}
+	return s.paid(symbols), nil
+}
+
+func (s *source) Capabilities() fquery.Capabilities {
+	return fquery.Capabilities{Actions: []fquery.Action{
+		fquery.ActionQuote, fquery.ActionHist, fquery.ActionHistLimit,
+		fquery.ActionDividendHist, fquery.ActionDividendHistLimit,
+	}}
+}
+
+func (s *source) String() string { return "test" }
+
+func newCache(t *testing.T, src fquery.Source) *SqliteCache {
+	t.Helper()
+	c, err := New(filepath.Join(t.TempDir(), "cache.db"), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestManySymbols(t *testing.T) {
+	src := newSource(1)
+	c := newCache(t, src)
+
+	/* more than fit in one query */
+	symbols := make([]string, 2*QUERY_SYMBOLS+1)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("S%v.XX", i)
+	}
+
+	for i := 0; i < 2; i++ {
+		quotes, err := c.Quote(symbols)
+		if err != nil || len(quotes) != len(symbols) {
+			t.Fatalf("got %v quotes, %v", len(quotes), err)
+		}
+		hists, err := c.Hist(symbols)
+		if err != nil || len(hists) != len(symbols) {
+			t.Fatalf("got %v histories, %v", len(hists), err)
+		}
+		divs, err := c.DividendHist(symbols)
+		if err != nil || len(divs) != len(symbols) {
+			t.Fatalf("got %v dividend histories, %v", len(divs), err)
+		}
+	}
+
+	/* the second time around everything came from the cache */
+	for _, action := range []string{"quote", "hist", "dividends"} {
+		if n := src.calls(action); n != len(symbols) {
+			t.Errorf("%v: asked the source for %v symbols, want %v", action, n, len(symbols))
+		}
+	}
+}
+
+func TestMergeQuotes(t *testing.T) {
+	c := newCache(t, newSource(1))
+
+	/* a quote replaces the one that's there */
+	for _, price := range []float64{1, 2} {
+		q := fquery.Quote{Symbol: "A.XX", Updated: time.Now(), LastTradePrice: price}
+		if err := c.mergeQuotes(q, fquery.Quote{Symbol: "B.XX", Updated: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quotes, err := c.loadQuotes([]string{"A.XX", "B.XX"})
+	if err != nil || len(quotes) != 2 {
+		t.Fatalf("got %v, %v", quotes, err)
+	}
+	for _, q := range quotes {
+		if q.Symbol == "A.XX" && q.LastTradePrice != 2 {
+			t.Errorf("got %+v", q)
+		}
+	}
+
+	/* and when it can't be stored, that's not kept quiet */
+	if _, err := c.gorp.Exec(`DROP TABLE quotes`); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.mergeQuotes(fquery.Quote{Symbol: "A.XX"}); err == nil {
+		t.Error("storing a quote without a table worked")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	c := newCache(t, newSource(1))
+	c.SetKeepSnapshots(true)
+	c.SetQuoteExpiry(0)
+
+	/* every fetch leaves one */
+	for i := 0; i < 2; i++ {
+		if _, err := c.Quote([]string{"A.XX"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snaps, err := c.Snapshots("A.XX", time.Now().Add(-time.Hour), time.Now())
+	if err != nil || len(snaps) != 2 || !snaps[0].Updated.Before(snaps[1].Updated) {
+		t.Fatalf("got %+v, %v", snaps, err)
+	}
+
+	/* the same quote twice is one snapshot, and no reason to fail */
+	east := time.FixedZone("UTC+5", 5*60*60)
+	at := func(hour int) time.Time { return time.Date(2014, time.January, 2, hour, 0, 0, 0, time.UTC) }
+	for _, hour := range []int{10, 12, 12} {
+		q := fquery.Quote{Symbol: "B.XX", Updated: at(hour).In(east), LastTradePrice: float64(hour)}
+		if err := c.mergeQuotes(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	/* whatever the location of the window */
+	for _, loc := range []*time.Location{time.UTC, east, time.Local} {
+		snaps, err := c.Snapshots("B.XX", at(11).In(loc), at(13).In(loc))
+		if err != nil || len(snaps) != 1 || !snaps[0].Updated.Equal(at(12)) {
+			t.Errorf("%v: got %+v, %v", loc, snaps, err)
+		}
+		snaps, err = c.Snapshots("B.XX", at(9).In(loc), at(12).In(loc))
+		if err != nil || len(snaps) != 2 {
+			t.Errorf("%v: got %v snapshots, %v", loc, len(snaps), err)
+		}
+	}
+
+	/* anything else that goes wrong is an error */
+	if _, err := c.gorp.Exec(`DROP TABLE quotesnapshots`); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.mergeQuotes(fquery.Quote{Symbol: "B.XX", Updated: time.Now()}); err == nil {
+		t.Error("storing a snapshot without a table worked")
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	src := newSource(0)
+	c := newCache(t, src)
+
+	today := dayOf(time.Now())
+	ago := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+	if _, err := c.Quote([]string{"A.XX"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.HistLimit([]string{"A.XX"}, ago(20), ago(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	/* everything has to be fetched again, but the source is down */
+	c.SetQuoteExpiry(0)
+	src.fail = true
+
+	quotes, err := c.Quote([]string{"A.XX", "B.XX"})
+	if len(quotes) != 0 || err == nil {
+		t.Errorf("got %v, %v without asking for stale quotes", quotes, err)
+	}
+	hists, err := c.HistLimit([]string{"A.XX"}, ago(20), today)
+	if len(hists) != 0 || err == nil {
+		t.Errorf("got %v, %v without asking for stale history", hists, err)
+	}
+
+	/* what the cache has comes back marked as stale, the errors are
+	 * still there */
+	c.SetStaleOnError(true)
+	quotes, err = c.Quote([]string{"A.XX", "B.XX"})
+	if len(quotes) != 1 || quotes[0].Symbol != "A.XX" || quotes[0].Stale <= 0 {
+		t.Errorf("got %+v", quotes)
+	}
+	if errs, ok := err.(fquery.SymbolErrors); !ok || len(errs) != 2 {
+		t.Errorf("got %v, want an error for both", err)
+	}
+
+	hists, err = c.HistLimit([]string{"A.XX"}, ago(20), today)
+	h := hists["A.XX"]
+	if len(h.Entries) != 16 || h.Stale < 4*day {
+		t.Errorf("got %v entries, stale by %v", len(h.Entries), h.Stale)
+	}
+	if errs, ok := err.(fquery.SymbolErrors); !ok || errs["A.XX"] == nil {
+		t.Errorf("got %v, want an error for A.XX", err)
+	}
+}
+
+func TestFlights(t *testing.T) {
+	src := newSource(30)
+	c := newCache(t, src)
+	today := dayOf(time.Now())
+
+	/* the first caller of each kind gets stuck in the source, the others
+	 * come in while it is. History and windows of it share flights. */
+	release := src.hold()
+	var wg sync.WaitGroup
+	run := func(fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	quote := func() error {
+		quotes, err := c.Quote([]string{"A.XX", "B.XX"})
+		if err == nil && len(quotes) != 2 {
+			err = fmt.Errorf("got %v quotes", len(quotes))
+		}
+		return err
+	}
+	hist := func() error {
+		hists, err := c.Hist([]string{"A.XX"})
+		if err == nil && len(hists["A.XX"].Entries) != 31 {
+			err = fmt.Errorf("got %v entries", len(hists["A.XX"].Entries))
+		}
+		return err
+	}
+	window := func() error {
+		hists, err := c.HistLimit([]string{"A.XX"}, today.AddDate(0, 0, -10), today.AddDate(0, 0, -5))
+		if err == nil && len(hists["A.XX"].Entries) != 6 {
+			err = fmt.Errorf("got %v entries in the window", len(hists["A.XX"].Entries))
+		}
+		return err
+	}
+
+	run(quote)
+	run(hist)
+	<-src.started
+	<-src.started
+	for i := 0; i < 5; i++ {
+		run(quote)
+		run(hist)
+		run(window)
+	}
+
+	/* whoever comes in late finds it in the cache */
+	time.Sleep(50 * time.Millisecond)
+	release()
+	wg.Wait()
+
+	if src.calls("quote") != 2 || src.calls("hist") != 1 || src.calls("histlimit") != 0 {
+		t.Errorf("asked the source too often: %v", src.asked)
+	}
+}
+
+func TestFlightCanceled(t *testing.T) {
+	src := newSource(30)
+	c := newCache(t, src)
+
+	/* the first caller gives up while the other waits for it */
+	release := src.hold()
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.QuoteContext(ctx, []string{"A.XX"})
+		first <- err
+	}()
+	<-src.started
+
+	second := make(chan error)
+	go func() {
+		quotes, err := c.Quote([]string{"A.XX"})
+		if err == nil && len(quotes) != 1 {
+			err = fmt.Errorf("got %v quotes", len(quotes))
+		}
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; fquery.KindOf(err) != fquery.KindCanceled {
+		t.Errorf("the first caller got %v", err)
+	}
+
+	/* that's no reason for the second to fail, it fetches it itself */
+	release()
+	if err := <-second; err != nil {
+		t.Errorf("the cancellation of the first caller leaked: %v", err)
+	}
+}
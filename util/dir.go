@@ -2,13 +2,14 @@ package util
 
 import (
 	"fmt"
+	"os"
 	"os/user"
 )
 
 func Home() string {
 	usr, err := user.Current()
 	if err != nil {
-		fmt.Println("error: can't get current user, returning working dir, ", err)
+		fmt.Fprintln(os.Stderr, "error: can't get current user, returning working dir, ", err)
 		return "."
 	}
 	return usr.HomeDir
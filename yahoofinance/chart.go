@@ -0,0 +1,38 @@
+package yahoofinance
+
+import (
+	"net/url"
+	"strings"
+)
+
+const CHART_URL = "http://chart.finance.yahoo.com/z"
+
+/* ChartRange is the time span a chart covers */
+type ChartRange string
+
+const (
+	Day1   ChartRange = "1d"
+	Day5   ChartRange = "5d"
+	Month3 ChartRange = "3m"
+	Month6 ChartRange = "6m"
+	Year1  ChartRange = "1y"
+	Year2  ChartRange = "2y"
+	Year5  ChartRange = "5y"
+	Max    ChartRange = "my"
+)
+
+/* GenChartUrl returns the url of a line chart of symbol with the 50- and
+ * 200-day moving averages drawn in. The symbols in compare (if any) are
+ * plotted alongside it, e.g.: an index or an exchange rate like
+ * EURUSD=X. */
+func GenChartUrl(symbol string, r ChartRange, compare []string) string {
+	params := url.Values{}
+	params.Set("s", symbol)
+	params.Set("t", string(r))
+	params.Set("q", "l")
+	params.Set("p", "m50,m200")
+	if len(compare) > 0 {
+		params.Set("c", strings.Join(compare, ","))
+	}
+	return CHART_URL + "?" + params.Encode()
+}
@@ -0,0 +1,262 @@
+package yahoofinance
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+/* the columns requested from the CSV interface, the order of the letters
+ * determines the order of the columns in the response:
+ *
+ *   s: symbol, n: name, x: exchange, v: volume, a2: avg. daily volume,
+ *   r: P/E, e: EPS, d: dividend/share, y: dividend yield, q: ex-div. date,
+ *   b: bid, a: ask, o: open, p: previous close, l1: last trade price,
+ *   g: day low, h: day high, j: year low, k: year high,
+ *   m3: 50-day moving avg., m4: 200-day moving avg. */
+const CSV_QUOTE_FORMAT = "snxva2reydqbaopl1ghjkm3m4"
+
+const (
+	csvSymbol = iota
+	csvName
+	csvExchange
+	csvVolume
+	csvAvgDailyVolume
+	csvPeRatio
+	csvEarningsPerShare
+	csvDividendPerShare
+	csvDividendYield
+	csvDividendExDate
+	csvBid
+	csvAsk
+	csvOpen
+	csvPreviousClose
+	csvLastTradePrice
+	csvDayLow
+	csvDayHigh
+	csvYearLow
+	csvYearHigh
+	csvMa50
+	csvMa200
+	csvNumColumns
+)
+
+func (s *Source) csvQuotes(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	params := url.Values{}
+	params.Set("s", strings.Join(symbols, "+"))
+	params.Set("f", CSV_QUOTE_FORMAT)
+	params.Set("e", ".csv")
+	url := s.csvURL + "?" + params.Encode()
+
+	vprintln("yahoofinance: fetching csv quotes,", url)
+	resp, err := s.get(ctx, url, symbols...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rows, err := readCsv(resp.Body, csvNumColumns)
+	if err != nil {
+		return nil, fmt.Errorf("csv: %v, url: %v", err, url)
+	}
+
+	errs := make(fquery.SymbolErrors)
+	quotes := make([]fquery.Quote, 0, len(rows))
+	returned := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		returned[strings.ToUpper(r[csvSymbol])] = true
+
+		/* unknown symbols come back with their own name and no price */
+		if (r[csvName] == "N/A" || r[csvName] == r[csvSymbol]) && csvFloat(r[csvLastTradePrice]) == 0 {
+			errs.Add(r[csvSymbol], fquery.KindUnknownSymbol,
+				fmt.Errorf("csv returned no data for the symbol"))
+			continue
+		}
+
+		quotes = append(quotes, fquery.Quote{
+			Symbol:           r[csvSymbol],
+			Name:             r[csvName],
+			Exchange:         r[csvExchange],
+			Updated:          time.Now(),
+			Volume:           csvInt(r[csvVolume]),
+			AvgDailyVolume:   csvInt(r[csvAvgDailyVolume]),
+			PeRatio:          csvFloat(r[csvPeRatio]),
+			EarningsPerShare: csvFloat(r[csvEarningsPerShare]),
+			DividendPerShare: csvFloat(r[csvDividendPerShare]),
+			DividendYield:    csvFloat(r[csvDividendYield]) / 100,
+			DividendExDate:   csvDate(r[csvDividendExDate]),
+			Bid:              csvFloat(r[csvBid]),
+			Ask:              csvFloat(r[csvAsk]),
+			Open:             csvFloat(r[csvOpen]),
+			PreviousClose:    csvFloat(r[csvPreviousClose]),
+			LastTradePrice:   csvFloat(r[csvLastTradePrice]),
+			DayLow:           csvFloat(r[csvDayLow]),
+			DayHigh:          csvFloat(r[csvDayHigh]),
+			YearLow:          csvFloat(r[csvYearLow]),
+			YearHigh:         csvFloat(r[csvYearHigh]),
+			Ma50:             csvFloat(r[csvMa50]),
+			Ma200:            csvFloat(r[csvMa200]),
+		})
+	}
+
+	for _, symbol := range symbols {
+		if !returned[strings.ToUpper(symbol)] {
+			errs.Add(symbol, fquery.KindUnknownSymbol, errors.New("csv didn't return it"))
+		}
+	}
+
+	return quotes, errs.Err()
+}
+
+/* start and end are optional, without them everything is returned */
+func (s *Source) csvHist(ctx context.Context, symbol string, start *time.Time, end *time.Time) (*fquery.Hist, error) {
+	rows, err := s.histCsv(ctx, symbol, "d", start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Date,Open,High,Low,Close,Volume,Adj Close */
+	entries := make([]fquery.HistEntry, 0, len(rows))
+	for _, r := range rows {
+		if len(r) < 7 {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+				fmt.Errorf("csv: expected 7 columns, got %v", len(r)))
+		}
+
+		t, err := time.Parse(util.FmtYearMonthDay, r[0])
+		if err != nil {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindLayout, err)
+		}
+
+		entries = append(entries, fquery.HistEntry{
+			Date:     util.YearMonthDay(t),
+			Open:     csvFloat(r[1]),
+			High:     csvFloat(r[2]),
+			Low:      csvFloat(r[3]),
+			Close:    csvFloat(r[4]),
+			Volume:   csvInt(r[5]),
+			AdjClose: csvFloat(r[6]),
+		})
+	}
+
+	if len(entries) == 0 {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+			fmt.Errorf("csv returned no history"))
+	}
+
+	return newHist(symbol, entries), nil
+}
+
+func (s *Source) csvDividends(ctx context.Context, symbol string, start *time.Time, end *time.Time) (*fquery.DividendHist, error) {
+	rows, err := s.histCsv(ctx, symbol, "v", start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Date,Dividends */
+	entries := make([]fquery.DividendEntry, 0, len(rows))
+	for _, r := range rows {
+		if len(r) < 2 {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+				fmt.Errorf("csv: expected 2 columns, got %v", len(r)))
+		}
+
+		t, err := time.Parse(util.FmtYearMonthDay, r[0])
+		if err != nil {
+			return nil, fquery.NewSymbolError(symbol, fquery.KindLayout, err)
+		}
+
+		entries = append(entries, fquery.DividendEntry{
+			Date:      util.YearMonthDay(t),
+			Dividends: csvFloat(r[1]),
+		})
+	}
+
+	return newDividendHist(symbol, entries), nil
+}
+
+/* requests the historical CSV file, interval is "d" (daily), "w"
+ * (weekly), "m" (monthly) or "v" (dividends). Returns the rows without
+ * the header. */
+func (s *Source) histCsv(ctx context.Context, symbol string, interval string, start *time.Time, end *time.Time) ([][]string, error) {
+	params := url.Values{}
+	params.Set("s", symbol)
+	params.Set("g", interval)
+	params.Set("ignore", ".csv")
+
+	/* months are zero-based */
+	if start != nil {
+		params.Set("a", strconv.Itoa(int(start.Month())-1))
+		params.Set("b", strconv.Itoa(start.Day()))
+		params.Set("c", strconv.Itoa(start.Year()))
+	}
+	if end != nil {
+		params.Set("d", strconv.Itoa(int(end.Month())-1))
+		params.Set("e", strconv.Itoa(end.Day()))
+		params.Set("f", strconv.Itoa(end.Year()))
+	}
+	url := s.histCsvURL + "?" + params.Encode()
+
+	vprintln("yahoofinance: fetching csv history,", url)
+	resp, err := s.get(ctx, url, symbol)
+	if err != nil {
+		return nil, symbolError(symbol, err)
+	}
+	defer resp.Body.Close()
+
+	rows, err := readCsv(resp.Body, -1)
+	if err != nil {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindLayout,
+			fmt.Errorf("csv: %v, url: %v", err, url))
+	}
+
+	if len(rows) > 0 && rows[0][0] == "Date" {
+		rows = rows[1:]
+	}
+	return rows, nil
+}
+
+/* reads all records, columns < 0 means the amount of columns isn't
+ * checked */
+func readCsv(r io.Reader, columns int) ([][]string, error) {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = columns
+	rd.TrimLeadingSpace = true
+	return rd.ReadAll()
+}
+
+/* the CSV interface uses N/A for missing data */
+func csvFloat(s string) float64 {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "%")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func csvInt(s string) int64 {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+func csvDate(s string) time.Time {
+	for _, format := range []string{util.FmtMonthDay, util.FmtDayTMonthYear, util.FmtYearMonthDay} {
+		if t, err := time.Parse(format, s); err == nil {
+			return pastYear(t)
+		}
+	}
+	return time.Time{}
+}
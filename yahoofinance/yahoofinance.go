@@ -0,0 +1,347 @@
+package yahoofinance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+var VERBOSITY = 0
+
+const (
+	YQL_URL      = "http://query.yahooapis.com/v1/public/yql"
+	CSV_URL      = "http://download.finance.yahoo.com/d/quotes.csv"
+	HIST_CSV_URL = "http://ichart.finance.yahoo.com/table.csv"
+
+	/* YQL and the CSV interface both choke when asked for too many symbols
+	 * at once, so quotes are requested in batches of this size */
+	QUOTE_BATCH_SIZE = 20
+)
+
+/* Backend selects the way quotes and history are requested, see NOTES */
+type Backend int
+
+const (
+	YQL Backend = iota /* YQL first, CSV when YQL fails */
+	CSV                /* CSV only */
+)
+
+type Source struct {
+	client  *http.Client
+	backend Backend
+
+	yqlURL     string
+	csvURL     string
+	histCsvURL string
+}
+
+/* Option configures a Source, pass them to New */
+type Option func(s *Source)
+
+/* WithClient makes the source use client instead of http.DefaultClient */
+func WithClient(client *http.Client) Option {
+	return func(s *Source) {
+		s.client = client
+	}
+}
+
+func WithBackend(backend Backend) Option {
+	return func(s *Source) {
+		s.backend = backend
+	}
+}
+
+/* WithYqlURL, WithCsvURL and WithHistCsvURL point the source somewhere
+ * else than Yahoo, useful for testing against a local stand-in */
+func WithYqlURL(url string) Option {
+	return func(s *Source) {
+		s.yqlURL = url
+	}
+}
+
+func WithCsvURL(url string) Option {
+	return func(s *Source) {
+		s.csvURL = url
+	}
+}
+
+func WithHistCsvURL(url string) Option {
+	return func(s *Source) {
+		s.histCsvURL = url
+	}
+}
+
+func New(opts ...Option) fquery.Source {
+	s := &Source{
+		client:     http.DefaultClient,
+		backend:    YQL,
+		yqlURL:     YQL_URL,
+		csvURL:     CSV_URL,
+		histCsvURL: HIST_CSV_URL,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Source) Quote(symbols []string) ([]fquery.Quote, error) {
+	return s.QuoteContext(context.Background(), symbols)
+}
+
+func (s *Source) QuoteContext(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	type batchResult struct {
+		batch  []string
+		quotes []fquery.Quote
+		err    error
+	}
+
+	batches := chunk(symbols, QUOTE_BATCH_SIZE)
+	results := make(chan batchResult, len(batches))
+
+	/* fetch all batches in parallel */
+	for _, batch := range batches {
+		go func(batch []string) {
+			quotes, err := s.quotes(ctx, batch)
+			results <- batchResult{batch, quotes, err}
+		}(batch)
+	}
+
+	slice := make([]fquery.Quote, 0, len(symbols))
+	errs := make(fquery.SymbolErrors)
+	pending := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		pending[symbol] = true
+	}
+
+	for i := 0; i < len(batches); i++ {
+		select {
+		case r := <-results:
+			for _, symbol := range r.batch {
+				delete(pending, symbol)
+			}
+			if r.err != nil {
+				vprintln("yahoofinance: error while fetching,", r.err)
+				errs.Merge(r.err, r.batch...)
+			}
+			slice = append(slice, r.quotes...)
+		case <-ctx.Done():
+			for symbol := range pending {
+				errs.Add(symbol, fquery.KindCanceled, ctx.Err())
+			}
+			return slice, errs.Err()
+		}
+	}
+
+	return slice, errs.Err()
+}
+
+func (s *Source) Hist(symbols []string) (map[string]fquery.Hist, error) {
+	return s.HistContext(context.Background(), symbols)
+}
+
+/* YQL only hands out history in limited windows, so the full history is
+ * always requested as CSV */
+func (s *Source) HistContext(ctx context.Context, symbols []string) (map[string]fquery.Hist, error) {
+	m := make(map[string]fquery.Hist, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		return s.csvHist(ctx, symbol, nil, nil)
+	}, func(symbol string, v interface{}) {
+		m[symbol] = *v.(*fquery.Hist)
+	})
+	return m, errs.Err()
+}
+
+func (s *Source) HistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	return s.HistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (s *Source) HistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.Hist, error) {
+	m := make(map[string]fquery.Hist, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		if s.backend == YQL {
+			hist, err := s.yqlHist(ctx, symbol, start, end)
+			if !shouldFallback(err) {
+				return hist, err
+			}
+			vprintln("yahoofinance: YQL failed for", symbol, "falling back to CSV,", err)
+		}
+		return s.csvHist(ctx, symbol, &start, &end)
+	}, func(symbol string, v interface{}) {
+		m[symbol] = *v.(*fquery.Hist)
+	})
+	return m, errs.Err()
+}
+
+func (s *Source) DividendHist(symbols []string) (map[string]fquery.DividendHist, error) {
+	return s.DividendHistContext(context.Background(), symbols)
+}
+
+func (s *Source) DividendHistContext(ctx context.Context, symbols []string) (map[string]fquery.DividendHist, error) {
+	m := make(map[string]fquery.DividendHist, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		return s.csvDividends(ctx, symbol, nil, nil)
+	}, func(symbol string, v interface{}) {
+		m[symbol] = *v.(*fquery.DividendHist)
+	})
+	return m, errs.Err()
+}
+
+func (s *Source) DividendHistLimit(symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	return s.DividendHistLimitContext(context.Background(), symbols, start, end)
+}
+
+func (s *Source) DividendHistLimitContext(ctx context.Context, symbols []string, start time.Time, end time.Time) (map[string]fquery.DividendHist, error) {
+	m := make(map[string]fquery.DividendHist, len(symbols))
+	errs := parallel(ctx, symbols, func(symbol string) (interface{}, error) {
+		if s.backend == YQL {
+			hist, err := s.yqlDividends(ctx, symbol, start, end)
+			if !shouldFallback(err) {
+				return hist, err
+			}
+			vprintln("yahoofinance: YQL failed for", symbol, "falling back to CSV,", err)
+		}
+		return s.csvDividends(ctx, symbol, &start, &end)
+	}, func(symbol string, v interface{}) {
+		m[symbol] = *v.(*fquery.DividendHist)
+	})
+	return m, errs.Err()
+}
+
+func (s *Source) Capabilities() fquery.Capabilities {
+	return fquery.Capabilities{
+		Actions: []fquery.Action{
+			fquery.ActionQuote,
+			fquery.ActionHist,
+			fquery.ActionHistLimit,
+			fquery.ActionDividendHist,
+			fquery.ActionDividendHistLimit,
+		},
+		HistRanges: []fquery.HistRange{{Name: "max", Span: 0}},
+		Intervals:  []fquery.Interval{fquery.Daily},
+		QuoteFields: []string{
+			"Symbol", "Name", "Exchange", "Updated",
+			"Volume", "AvgDailyVolume",
+			"PeRatio", "EarningsPerShare", "DividendPerShare",
+			"DividendYield", "DividendExDate",
+			"Bid", "Ask", "Open", "PreviousClose", "LastTradePrice",
+			"DayLow", "DayHigh", "YearLow", "YearHigh",
+			"Ma50", "Ma200",
+		},
+	}
+}
+
+func (s *Source) String() string {
+	return "Yahoo Finance"
+}
+
+/* fetches a batch of quotes with the configured backend */
+func (s *Source) quotes(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	if s.backend == YQL {
+		quotes, err := s.yqlQuotes(ctx, symbols)
+		if !shouldFallback(err) {
+			return quotes, err
+		}
+		vprintln("yahoofinance: YQL failed for", symbols, "falling back to CSV,", err)
+	}
+	return s.csvQuotes(ctx, symbols)
+}
+
+/* unknown symbols won't be any different with CSV, and a cancelled
+ * request stays cancelled, everything else is worth a retry */
+func shouldFallback(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch fquery.KindOf(err) {
+	case fquery.KindCanceled, fquery.KindUnknownSymbol:
+		return false
+	}
+	return true
+}
+
+/* like http.Get, but aborts the request when ctx is done. An answer
+ * other than 200 is an error of all symbols the request was for. */
+func (s *Source) get(ctx context.Context, url string, symbols ...string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	/* yahoo answers with a 404 for symbols it doesn't know, everything
+	 * else is treated as the site being unavailable */
+	kind := fquery.KindNetwork
+	if resp.StatusCode == http.StatusNotFound {
+		kind = fquery.KindUnknownSymbol
+	}
+	err = fmt.Errorf("url: %v, status: %v", url, resp.Status)
+	if len(symbols) == 1 {
+		return nil, fquery.NewSymbolError(symbols[0], kind, err)
+	}
+	errs := make(fquery.SymbolErrors)
+	for _, symbol := range symbols {
+		errs.Add(symbol, kind, err)
+	}
+	return nil, errs
+}
+
+/* tags err with symbol, classifying it if it wasn't already */
+func symbolError(symbol string, err error) *fquery.SymbolError {
+	if serr, ok := err.(*fquery.SymbolError); ok {
+		return serr
+	}
+	return fquery.NewSymbolError(symbol, fquery.KindOf(err), err)
+}
+
+/* fquery.Parallel, with the errors logged */
+func parallel(ctx context.Context, symbols []string,
+	fetch func(symbol string) (interface{}, error),
+	collect func(symbol string, v interface{})) fquery.SymbolErrors {
+
+	errs := fquery.Parallel(ctx, symbols, fetch, collect)
+	for _, symbol := range errs.Symbols() {
+		vprintln("yahoofinance: error while fetching,", errs[symbol])
+	}
+	return errs
+}
+
+func chunk(xs []string, size int) [][]string {
+	chunks := make([][]string, 0, len(xs)/size+1)
+	for len(xs) > size {
+		chunks = append(chunks, xs[:size])
+		xs = xs[size:]
+	}
+	if len(xs) > 0 {
+		chunks = append(chunks, xs)
+	}
+	return chunks
+}
+
+func vprintln(a ...interface{}) (int, error) {
+	if VERBOSITY > 0 {
+		return fmt.Fprintln(os.Stderr, a...)
+	}
+
+	return 0, nil
+}
+
+func vprintf(format string, a ...interface{}) (int, error) {
+	if VERBOSITY > 0 {
+		return fmt.Fprintf(os.Stderr, format, a...)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,211 @@
+package yahoofinance
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+)
+
+const (
+	yqlQuoteResponse = `{"query":{"count":1,"created":"2014-02-03T10:00:00Z","lang":"en-US","results":{"quote":
+		{"symbol":"VEUR.AS","Name":"VANGUARD FTSE DEV","StockExchange":"AMS","Volume":"10432",
+		 "AverageDailyVolume":"20000","PERatio":null,"EarningsShare":null,"DividendShare":"0.73",
+		 "DividendYield":"2.84","ExDividendDate":"Dec 18","Bid":"25.60","Ask":"25.65","Open":"25.50",
+		 "PreviousClose":"25.45","LastTradePriceOnly":"25.62","DaysLow":"25.40","DaysHigh":"25.70",
+		 "YearLow":"21.10","YearHigh":"26.00","FiftydayMovingAverage":"25.1",
+		 "TwoHundreddayMovingAverage":"24.3","ErrorIndicationreturnedforsymbolchangedinvalid":null}}}}`
+
+	yqlErrorResponse = `{"error":{"lang":"en-US","description":"Too many instructions executed: 50000033"}}`
+
+	csvQuoteResponse = "\"VEUR.AS\",\"VANGUARD FTSE DEV\",\"AMS\",10432,20000,N/A,N/A,0.73,2.84,\"Dec 18\",25.60,25.65,25.50,25.45,25.62,25.40,25.70,21.10,26.00,25.1,24.3\n" +
+		"\"NOPE\",\"NOPE\",\"N/A\",N/A,N/A,N/A,N/A,N/A,N/A,\"N/A\",N/A,N/A,N/A,N/A,0.00,N/A,N/A,N/A,N/A,N/A,N/A\n"
+
+	csvHistResponse = "Date,Open,High,Low,Close,Volume,Adj Close\n" +
+		"2014-01-03,25.50,25.70,25.40,25.62,10432,25.62\n" +
+		"2014-01-02,25.30,25.55,25.20,25.45,9000,25.45\n"
+
+	csvDividendResponse = "Date,Dividends\n" +
+		"2013-12-18,0.18\n" +
+		"2013-09-18,0.25\n"
+)
+
+/* a stand-in for the Yahoo Finance servers, yql answers with whatever
+ * the test wants */
+func newServer(t *testing.T, yql string) (*httptest.Server, fquery.ContextSource) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/yql", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, yql)
+	})
+	mux.HandleFunc("/quotes.csv", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, csvQuoteResponse)
+	})
+	mux.HandleFunc("/table.csv", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("s") {
+		case "VEUR.AS":
+			if r.URL.Query().Get("g") == "v" {
+				fmt.Fprint(w, csvDividendResponse)
+			} else {
+				fmt.Fprint(w, csvHistResponse)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	src := New(
+		WithClient(srv.Client()),
+		WithYqlURL(srv.URL+"/yql"),
+		WithCsvURL(srv.URL+"/quotes.csv"),
+		WithHistCsvURL(srv.URL+"/table.csv"),
+	)
+	return srv, src.(fquery.ContextSource)
+}
+
+func checkQuote(t *testing.T, q fquery.Quote) {
+	if q.Symbol != "VEUR.AS" || q.Name != "VANGUARD FTSE DEV" {
+		t.Errorf("wrong symbol or name: %v, %v", q.Symbol, q.Name)
+	}
+	if q.LastTradePrice != 25.62 || q.Bid != 25.60 || q.Ask != 25.65 {
+		t.Errorf("wrong prices: %v, %v/%v", q.LastTradePrice, q.Bid, q.Ask)
+	}
+	if q.Volume != 10432 || q.AvgDailyVolume != 20000 {
+		t.Errorf("wrong volume: %v, %v", q.Volume, q.AvgDailyVolume)
+	}
+	if math.Abs(q.DividendYield-0.0284) > 1e-9 {
+		t.Errorf("wrong dividend yield: %v", q.DividendYield)
+	}
+	if q.DividendExDate.Month() != time.December || q.DividendExDate.Day() != 18 || q.DividendExDate.After(time.Now()) {
+		t.Errorf("wrong ex-dividend date: %v", q.DividendExDate)
+	}
+	if q.Ma50 != 25.1 || q.Ma200 != 24.3 {
+		t.Errorf("wrong moving averages: %v, %v", q.Ma50, q.Ma200)
+	}
+}
+
+func TestQuoteYql(t *testing.T) {
+	srv, src := newServer(t, yqlQuoteResponse)
+	defer srv.Close()
+
+	quotes, err := src.Quote([]string{"VEUR.AS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 1 {
+		t.Fatalf("expected 1 quote, got %v", len(quotes))
+	}
+	checkQuote(t, quotes[0])
+}
+
+func TestQuoteFallbackToCsv(t *testing.T) {
+	srv, src := newServer(t, yqlErrorResponse)
+	defer srv.Close()
+
+	/* GONE isn't in the response at all */
+	quotes, err := src.Quote([]string{"VEUR.AS", "NOPE", "GONE"})
+	if len(quotes) != 1 {
+		t.Fatalf("expected 1 quote, got %v", len(quotes))
+	}
+	checkQuote(t, quotes[0])
+
+	errs, ok := err.(fquery.SymbolErrors)
+	if !ok {
+		t.Fatalf("expected SymbolErrors, got %v", err)
+	}
+	if len(errs) != 2 || errs.Kind("NOPE") != fquery.KindUnknownSymbol || errs.Kind("GONE") != fquery.KindUnknownSymbol {
+		t.Errorf("expected NOPE and GONE to be unknown, got %v", errs)
+	}
+}
+
+func TestHist(t *testing.T) {
+	srv, src := newServer(t, yqlErrorResponse)
+	defer srv.Close()
+
+	start := time.Date(2014, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2014, time.January, 31, 0, 0, 0, 0, time.UTC)
+	hists, err := src.HistLimit([]string{"VEUR.AS", "NOPE"}, start, end)
+
+	hist, ok := hists["VEUR.AS"]
+	if !ok {
+		t.Fatalf("no history for VEUR.AS: %v", err)
+	}
+	if len(hist.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", len(hist.Entries))
+	}
+
+	/* entries should be in chronological order */
+	first := hist.Entries[0]
+	if first.Date.GetTime().Day() != 2 || first.Close != 25.45 || first.Volume != 9000 {
+		t.Errorf("wrong first entry: %+v", first)
+	}
+	if hist.To.Day() != 3 {
+		t.Errorf("wrong end of history: %v", hist.To)
+	}
+
+	if fquery.KindOf(err) != fquery.KindUnknownSymbol {
+		t.Errorf("expected NOPE to be unknown, got %v", err)
+	}
+}
+
+func TestDividendHist(t *testing.T) {
+	srv, src := newServer(t, yqlErrorResponse)
+	defer srv.Close()
+
+	divs, err := src.DividendHist([]string{"VEUR.AS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := divs["VEUR.AS"].Dividends
+	if len(d) != 2 || d[0].Dividends != 0.25 || d[1].Dividends != 0.18 {
+		t.Errorf("wrong dividends: %+v", d)
+	}
+}
+
+func TestQuoteYqlMissing(t *testing.T) {
+	srv, src := newServer(t, yqlQuoteResponse)
+	defer srv.Close()
+
+	quotes, err := src.Quote([]string{"VEUR.AS", "GONE"})
+	if len(quotes) != 1 {
+		t.Fatalf("expected 1 quote, got %v", len(quotes))
+	}
+	errs, ok := err.(fquery.SymbolErrors)
+	if !ok || len(errs) != 1 || errs.Kind("GONE") != fquery.KindUnknownSymbol {
+		t.Errorf("expected GONE to be unknown, got %v", err)
+	}
+}
+
+/* every answer but a 200 is an error of all symbols that were asked for */
+func TestStatus(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	src := New(
+		WithClient(srv.Client()),
+		WithYqlURL(srv.URL+"/yql"),
+		WithCsvURL(srv.URL+"/quotes.csv"),
+		WithHistCsvURL(srv.URL+"/table.csv"),
+	)
+
+	_, err := src.Quote([]string{"A", "B"})
+	errs, ok := err.(fquery.SymbolErrors)
+	if !ok || len(errs) != 2 || errs.Kind("A") != fquery.KindNetwork || errs.Kind("B") != fquery.KindNetwork {
+		t.Errorf("expected network errors for A and B, got %v", err)
+	}
+
+	status = http.StatusNotFound
+	_, err = src.Hist([]string{"A"})
+	errs, ok = err.(fquery.SymbolErrors)
+	if !ok || len(errs) != 1 || errs.Kind("A") != fquery.KindUnknownSymbol {
+		t.Errorf("expected A to be unknown, got %v", err)
+	}
+}
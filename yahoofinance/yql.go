@@ -0,0 +1,283 @@
+package yahoofinance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aktau/gofinance/fquery"
+	"github.com/aktau/gofinance/util"
+)
+
+const (
+	/* the yahoo.finance.* tables are community tables */
+	YQL_ENV = "store://datatables.org/alltableswithkeys"
+
+	/* yahoo.finance.historicaldata refuses windows much longer than a
+	 * year, so longer ones are split up */
+	YQL_MAX_WINDOW = 365 * 24 * time.Hour
+)
+
+type yqlResponse struct {
+	Query struct {
+		Count   int             `json:"count"`
+		Results json.RawMessage `json:"results"`
+	} `json:"query"`
+
+	/* YQL reports errors like "Too many instructions executed" here */
+	Error *struct {
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+/* YQL returns (almost) everything as strings, or null */
+type yqlQuote struct {
+	Symbol        string
+	Name          string
+	StockExchange string
+
+	Volume             util.NullInt64
+	AverageDailyVolume util.NullInt64
+
+	PERatio        util.NullFloat64
+	EarningsShare  util.NullFloat64
+	DividendShare  util.NullFloat64
+	DividendYield  util.NullFloat64 /* in percent */
+	ExDividendDate util.MonthDay
+
+	Bid, Ask           util.NullFloat64
+	Open               util.NullFloat64
+	PreviousClose      util.NullFloat64
+	LastTradePriceOnly util.NullFloat64
+
+	DaysLow, DaysHigh util.NullFloat64
+	YearLow, YearHigh util.NullFloat64
+
+	FiftydayMovingAverage      util.NullFloat64
+	TwoHundreddayMovingAverage util.NullFloat64
+
+	ErrorIndicationreturnedforsymbolchangedinvalid string
+}
+
+type yqlHistEntry struct {
+	Date      util.YearMonthDay
+	Open      util.NullFloat64
+	Close     util.NullFloat64
+	Adj_Close util.NullFloat64
+	High      util.NullFloat64
+	Low       util.NullFloat64
+	Volume    util.NullInt64
+}
+
+/* yahoo's tables want double quotes around strings, symbols don't
+ * contain any so they're simply dropped */
+func yqlString(s string) string {
+	return `"` + strings.Replace(s, `"`, "", -1) + `"`
+}
+
+func (s *Source) yqlQuotes(ctx context.Context, symbols []string) ([]fquery.Quote, error) {
+	quoted := util.MapStr(yqlString, symbols)
+	query := fmt.Sprintf(`select * from yahoo.finance.quotes where symbol in (%v)`,
+		strings.Join(quoted, ","))
+
+	var rows []yqlQuote
+	if err := s.yql(ctx, query, "quote", &rows, symbols...); err != nil {
+		return nil, err
+	}
+
+	errs := make(fquery.SymbolErrors)
+	quotes := make([]fquery.Quote, 0, len(rows))
+	returned := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		returned[strings.ToUpper(r.Symbol)] = true
+
+		if r.ErrorIndicationreturnedforsymbolchangedinvalid != "" || (r.Name == "" && r.LastTradePriceOnly == 0) {
+			errs.Add(r.Symbol, fquery.KindUnknownSymbol,
+				fmt.Errorf("yql doesn't know the symbol %v", r.ErrorIndicationreturnedforsymbolchangedinvalid))
+			continue
+		}
+
+		quotes = append(quotes, fquery.Quote{
+			Symbol:           r.Symbol,
+			Name:             r.Name,
+			Exchange:         r.StockExchange,
+			Updated:          time.Now(),
+			Volume:           int64(r.Volume),
+			AvgDailyVolume:   int64(r.AverageDailyVolume),
+			PeRatio:          float64(r.PERatio),
+			EarningsPerShare: float64(r.EarningsShare),
+			DividendPerShare: float64(r.DividendShare),
+			DividendYield:    float64(r.DividendYield) / 100,
+			DividendExDate:   pastYear(r.ExDividendDate.GetTime()),
+			Bid:              float64(r.Bid),
+			Ask:              float64(r.Ask),
+			Open:             float64(r.Open),
+			PreviousClose:    float64(r.PreviousClose),
+			LastTradePrice:   float64(r.LastTradePriceOnly),
+			DayLow:           float64(r.DaysLow),
+			DayHigh:          float64(r.DaysHigh),
+			YearLow:          float64(r.YearLow),
+			YearHigh:         float64(r.YearHigh),
+			Ma50:             float64(r.FiftydayMovingAverage),
+			Ma200:            float64(r.TwoHundreddayMovingAverage),
+		})
+	}
+
+	for _, symbol := range symbols {
+		if !returned[strings.ToUpper(symbol)] {
+			errs.Add(symbol, fquery.KindUnknownSymbol, errors.New("yql didn't return it"))
+		}
+	}
+
+	return quotes, errs.Err()
+}
+
+func (s *Source) yqlHist(ctx context.Context, symbol string, start time.Time, end time.Time) (*fquery.Hist, error) {
+	entries := make([]fquery.HistEntry, 0, 365)
+	for _, w := range windows(start, end, YQL_MAX_WINDOW) {
+		query := fmt.Sprintf(`select * from yahoo.finance.historicaldata `+
+			`where symbol = %v and startDate = "%v" and endDate = "%v"`,
+			yqlString(symbol), w[0].Format(util.FmtYearMonthDay), w[1].Format(util.FmtYearMonthDay))
+
+		var rows []yqlHistEntry
+		if err := s.yql(ctx, query, "quote", &rows, symbol); err != nil {
+			return nil, err
+		}
+
+		for _, r := range rows {
+			entries = append(entries, fquery.HistEntry{
+				Date:     r.Date,
+				Open:     float64(r.Open),
+				Close:    float64(r.Close),
+				AdjClose: float64(r.Adj_Close),
+				High:     float64(r.High),
+				Low:      float64(r.Low),
+				Volume:   int64(r.Volume),
+			})
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fquery.NewSymbolError(symbol, fquery.KindUnknownSymbol,
+			fmt.Errorf("yql returned no history between %v and %v", start, end))
+	}
+
+	return newHist(symbol, entries), nil
+}
+
+func (s *Source) yqlDividends(ctx context.Context, symbol string, start time.Time, end time.Time) (*fquery.DividendHist, error) {
+	query := fmt.Sprintf(`select * from yahoo.finance.dividendhistory `+
+		`where symbol = %v and startDate = "%v" and endDate = "%v"`,
+		yqlString(symbol), start.Format(util.FmtYearMonthDay), end.Format(util.FmtYearMonthDay))
+
+	var rows []fquery.DividendEntry
+	if err := s.yql(ctx, query, "quote", &rows, symbol); err != nil {
+		return nil, err
+	}
+
+	return newDividendHist(symbol, rows), nil
+}
+
+/* performs a YQL query about symbols and decodes the rows found under
+ * query.results.<key> into v, which must be a pointer to a slice */
+func (s *Source) yql(ctx context.Context, query string, key string, v interface{}, symbols ...string) error {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+	params.Set("env", YQL_ENV)
+	url := s.yqlURL + "?" + params.Encode()
+
+	vprintln("yahoofinance: yql query,", query)
+	resp, err := s.get(ctx, url, symbols...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r yqlResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("yql: json decode error, url: %v, error: %w", url, err)
+	}
+
+	if r.Error != nil {
+		return fmt.Errorf("yql: %v", r.Error.Description)
+	}
+
+	if r.Query.Count == 0 || len(r.Query.Results) == 0 || string(r.Query.Results) == "null" {
+		return nil
+	}
+
+	var results map[string]json.RawMessage
+	if err := json.Unmarshal(r.Query.Results, &results); err != nil {
+		return fmt.Errorf("yql: unexpected results, url: %v, error: %w", url, err)
+	}
+
+	/* when there's only one row, YQL doesn't bother with an array */
+	rows := results[key]
+	if r.Query.Count == 1 && len(rows) > 0 && rows[0] != '[' {
+		rows = append(append([]byte{'['}, rows...), ']')
+	}
+
+	if err := json.Unmarshal(rows, v); err != nil {
+		return fmt.Errorf("yql: unexpected rows, url: %v, error: %w", url, err)
+	}
+	return nil
+}
+
+/* splits [start, end] into consecutive windows no longer than max */
+func windows(start time.Time, end time.Time, max time.Duration) [][2]time.Time {
+	var ws [][2]time.Time
+	for start.Before(end) {
+		wend := start.Add(max)
+		if wend.After(end) {
+			wend = end
+		}
+		ws = append(ws, [2]time.Time{start, wend})
+		start = wend.Add(24 * time.Hour)
+	}
+	if len(ws) == 0 {
+		ws = append(ws, [2]time.Time{start, end})
+	}
+	return ws
+}
+
+/* Yahoo sometimes leaves out the year ("Jan 14"), in which case it's
+ * the last such date that isn't in the future */
+func pastYear(t time.Time) time.Time {
+	if t.IsZero() || t.Year() != 0 {
+		return t
+	}
+
+	now := time.Now()
+	t = time.Date(now.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if t.After(now) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+/* sorts the entries chronologically and fills in From/To */
+func newHist(symbol string, entries []fquery.HistEntry) *fquery.Hist {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Date.GetTime().Before(entries[j].Date.GetTime())
+	})
+
+	hist := &fquery.Hist{Symbol: symbol, Entries: entries}
+	if len(entries) > 0 {
+		hist.From = entries[0].Date.GetTime()
+		hist.To = entries[len(entries)-1].Date.GetTime()
+	}
+	return hist
+}
+
+func newDividendHist(symbol string, entries []fquery.DividendEntry) *fquery.DividendHist {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Date.GetTime().Before(entries[j].Date.GetTime())
+	})
+	return &fquery.DividendHist{Symbol: symbol, Dividends: entries}
+}